@@ -1,14 +1,44 @@
 package build
 
 import (
-    "github.com/fosslinux/vxb/vpkgs"
     "github.com/fosslinux/vxb/cfg"
+    "github.com/fosslinux/vxb/vpkgs"
     "fmt"
     str "strings"
+    "time"
 )
 
+// Make a single attempt at building a job
+func attempt(executor Executor, job Job, ident string, log *Log) error {
+    // Creating the masterdir is part of the attempt
+    log.startAttempt(job.MountType)
+    err := executor.Prepare(job)
+    // The command may depend on what was prepared
+    log.attemptCommand(executor.Command(job))
+    if err != nil {
+        // Attempt to clean up whatever was made
+        log.finishAttempt(err)
+        executor.Cleanup(job)
+        return err
+    }
+
+    // Perform operation
+    err = executor.Run(job, log)
+    log.finishAttempt(err)
+    if err != nil {
+        // Attempt to remove masterdir
+        executor.Cleanup(job)
+        return fmt.Errorf("%w building %s", err, ident)
+    }
+    return nil
+}
+
 // Specific wrapper command for building
-func Build(ident string, cfg cfg.Cfgs) error {
+// If force is set, the package is built even if it is already in the
+// repository. The output of the build is written to log. A failed build is
+// retried following the retry policy of the package, each time in a fresh
+// masterdir.
+func Build(executor Executor, ident string, force bool, log *Log, cfg cfg.Cfgs) error {
     var err error
 
     splitIdent := str.Split(ident, "@")
@@ -20,24 +50,40 @@ func Build(ident string, cfg cfg.Cfgs) error {
     if !exists {
         mountType = cfg.MountDefault
     }
+    job := Job{Pkgname: pkgname, Arch: arch, MountType: mountType, Force: force}
+
+    policy := cfg.Retry(pkgname)
+    backoff := policy.Backoff
+    for i := 1; i <= policy.Attempts; i++ {
+        err = attempt(executor, job, ident, log)
+        if err == nil || i == policy.Attempts {
+            break
+        }
 
-    // Create masterdir
-    err = vpkgs.CreateMasterdir(mountType, cfg)
+        // Maybe it will work with a different mount
+        if policy.FallbackMount != "" {
+            job.MountType = policy.FallbackMount
+        }
+        fmt.Fprintf(log, "WARN: %s (attempt %d of %d), retrying in %s with mount type %s...\n",
+            err, i, policy.Attempts, backoff, job.MountType)
+        time.Sleep(backoff)
+        backoff *= 2
+    }
     if err != nil {
         return err
     }
 
-    // Perform operation
-    args := "pkg -N " + pkgname
-    _, err = vpkgs.XbpsSrc(args, arch, mountType, true, cfg)
+    // Get the built packages
+    err = executor.Collect(job)
     if err != nil {
-        // Attempt to remove masterdir
-        vpkgs.RemoveMasterdir(cfg)
-        return fmt.Errorf("%w building %s", err, ident)
+        executor.Cleanup(job)
+        return fmt.Errorf("%w collecting %s", err, ident)
     }
+    // What is ready for this arch has now changed
+    vpkgs.InvalidateReady(arch)
 
     // Remove masterdir
-    err = vpkgs.RemoveMasterdir(cfg)
+    err = executor.Cleanup(job)
     if err != nil {
         return err
     }
@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package build
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "errors"
+    "io"
+    "io/ioutil"
+    str "strings"
+    "testing"
+    "time"
+)
+
+// An executor whose preparation takes a while and may fail
+type slowExecutor struct {
+    prepareTime time.Duration
+    prepareErr error
+    // Set by Prepare, like a masterdir name
+    prepared string
+}
+
+func (e *slowExecutor) Prepare(job Job) error {
+    time.Sleep(e.prepareTime)
+    e.prepared = "masterdir-" + job.Pkgname
+    return e.prepareErr
+}
+
+func (e *slowExecutor) Command(job Job) []string {
+    return []string{"xbps-src", "-m", e.prepared, job.pkgArgs()}
+}
+
+func (e *slowExecutor) Run(job Job, logs io.Writer) error {
+    return nil
+}
+
+func (e *slowExecutor) Collect(job Job) error {
+    return nil
+}
+
+func (e *slowExecutor) Cleanup(job Job) error {
+    return nil
+}
+
+func TestAttemptTimesPrepare(t *testing.T) {
+    tests := []struct {
+        name string
+        prepareErr error
+        wantStatus string
+    }{
+        {"prepared", nil, "0"},
+        {"preparing failed", errors.New("no space left"), "no space left"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            executor := &slowExecutor{prepareTime: 50 * time.Millisecond, prepareErr: test.prepareErr}
+            log, err := NewLog("foo@x86_64", "", ioutil.Discard)
+            if err != nil {
+                t.Fatal(err)
+            }
+            Build(executor, "foo@x86_64", false, log, cfg.Cfgs{MountDefault: "none"})
+
+            attempts := log.Attempts()
+            if len(attempts) != 1 {
+                t.Fatalf("%d attempts", len(attempts))
+            }
+            attempt := attempts[0]
+            if took := attempt.Finished.Sub(attempt.Started); took < executor.prepareTime {
+                t.Errorf("attempt took %s, less than preparing", took)
+            }
+            if attempt.Status != test.wantStatus {
+                t.Errorf("status is %q, want %q", attempt.Status, test.wantStatus)
+            }
+            if len(attempt.Command) < 3 || attempt.Command[2] != "masterdir-foo" {
+                t.Errorf("command is %q", attempt.Command)
+            }
+        })
+    }
+}
+
+// An executor that fails its first few runs, recording what it is asked to do
+type flakyExecutor struct {
+    failures int
+    calls []string
+}
+
+func (e *flakyExecutor) Prepare(job Job) error {
+    e.calls = append(e.calls, "prepare " + job.MountType)
+    return nil
+}
+
+func (e *flakyExecutor) Command(job Job) []string {
+    return []string{"xbps-src", job.pkgArgs()}
+}
+
+func (e *flakyExecutor) Run(job Job, logs io.Writer) error {
+    e.calls = append(e.calls, "run")
+    if e.failures > 0 {
+        e.failures--
+        return errors.New("build failed")
+    }
+    return nil
+}
+
+func (e *flakyExecutor) Collect(job Job) error {
+    e.calls = append(e.calls, "collect")
+    return nil
+}
+
+func (e *flakyExecutor) Cleanup(job Job) error {
+    e.calls = append(e.calls, "cleanup")
+    return nil
+}
+
+func TestBuildRetries(t *testing.T) {
+    tests := []struct {
+        name string
+        failures int
+        policy cfg.RetryPolicy
+        wantErr bool
+        wantCalls string
+    }{
+        {"first time", 0, cfg.RetryPolicy{Attempts: 3},
+            false, "prepare tmpfs, run, collect, cleanup"},
+        {"no retries", 1, cfg.RetryPolicy{Attempts: 1},
+            true, "prepare tmpfs, run, cleanup"},
+        {"retried", 1, cfg.RetryPolicy{Attempts: 3, Backoff: time.Millisecond},
+            false, "prepare tmpfs, run, cleanup, prepare tmpfs, run, collect, cleanup"},
+        {"fallback mount", 2, cfg.RetryPolicy{Attempts: 3, Backoff: time.Millisecond, FallbackMount: "none"},
+            false, "prepare tmpfs, run, cleanup, prepare none, run, cleanup, prepare none, run, collect, cleanup"},
+        {"out of attempts", 5, cfg.RetryPolicy{Attempts: 2, Backoff: time.Millisecond, FallbackMount: "none"},
+            true, "prepare tmpfs, run, cleanup, prepare none, run, cleanup"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            executor := &flakyExecutor{failures: test.failures}
+            log, err := NewLog("foo@x86_64", "", ioutil.Discard)
+            if err != nil {
+                t.Fatal(err)
+            }
+            err = Build(executor, "foo@x86_64", false, log, cfg.Cfgs{MountDefault: "tmpfs",
+                RetryDefault: test.policy})
+            if (err != nil) != test.wantErr {
+                t.Errorf("error is %v", err)
+            }
+            if calls := str.Join(executor.calls, ", "); calls != test.wantCalls {
+                t.Errorf("calls are %q, want %q", calls, test.wantCalls)
+            }
+
+            // Every attempt is logged
+            wantAttempts := str.Count(test.wantCalls, "prepare")
+            if attempts := log.Attempts(); len(attempts) != wantAttempts {
+                t.Errorf("%d attempts logged, want %d", len(attempts), wantAttempts)
+            }
+        })
+    }
+}
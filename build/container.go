@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package build
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "github.com/fosslinux/vxb/util"
+    "github.com/fosslinux/vxb/vpkgs"
+    "errors"
+    "fmt"
+    "io"
+    "os/exec"
+    str "strings"
+    "time"
+)
+
+// Where things are inside the container
+const (
+    containerVpkgPath = "/void-packages"
+    containerMasterdir = "/masterdir"
+)
+
+// Build packages with xbps-src inside a container (docker, podman, etc)
+// Each package is built in a fresh container, whose masterdir is thrown away
+// with it.
+type Container struct {
+    cfg cfg.Cfgs
+    // Name of the container currently building
+    name string
+}
+
+// Check the image is available locally
+func (container *Container) Prepare(job Job) error {
+    container.name = fmt.Sprintf("vxb-%s-%s-%d", job.Pkgname, job.Arch, time.Now().UnixNano())
+
+    cmd := exec.Command(container.cfg.ContainerRuntime, "image", "inspect", container.cfg.ContainerImage)
+    out, err := cmd.CombinedOutput()
+    if err != nil {
+        fmt.Printf("%s\n", string(out[:]))
+        return fmt.Errorf("Error %w while executing %s", err, cmd.Args)
+    }
+    return nil
+}
+
+// Arguments to the container runtime to build a package
+func (container *Container) runArgs(job Job) []string {
+    cfg := container.cfg
+    args := []string{"run", "--rm", "--name", container.name,
+        "-v", cfg.VpkgPath + ":" + containerVpkgPath,
+        "-v", cfg.VpkgPath + "/hostdir/binpkgs:" + containerVpkgPath + "/hostdir/binpkgs",
+        "-w", containerVpkgPath}
+
+    // Every mount type is a tmpfs inside a container
+    if job.MountType != "none" {
+        args = append(args, "--tmpfs", containerMasterdir + ":exec,size=" + cfg.MountSize[job.MountType])
+    }
+    args = append(args, cfg.ContainerArgs...)
+
+    // Bootstrap the masterdir then build the package, all in the one
+    // container
+    bootstrap := vpkgs.XbpsSrcArgs("binary-bootstrap " + cfg.HostArch, cfg.HostArch, containerMasterdir, cfg)
+    pkg := vpkgs.XbpsSrcArgs(job.pkgArgs(), job.Arch, containerMasterdir, cfg)
+    script := "./xbps-src " + str.Join(bootstrap, " ") + " && ./xbps-src " + str.Join(pkg, " ")
+
+    return append(args, cfg.ContainerImage, "sh", "-c", script)
+}
+
+// The container runtime command building the package
+func (container *Container) Command(job Job) []string {
+    return append([]string{container.cfg.ContainerRuntime}, container.runArgs(job)...)
+}
+
+// Build the package in a new container
+func (container *Container) Run(job Job, logs io.Writer) error {
+    command := container.Command(job)
+    cmd := exec.Command(command[0], command[1:]...)
+    cmd.Stdout = logs
+    cmd.Stderr = logs
+    err := util.RunTimeout(cmd, container.cfg.Timeout(job.Pkgname))
+    if errors.Is(err, util.ErrTimeout) {
+        // Killing the runtime CLI does not stop the container itself
+        exec.Command(container.cfg.ContainerRuntime, "kill", container.name).Run()
+    }
+    if err != nil {
+        return fmt.Errorf("Error %w while executing %s", err, cmd.Args)
+    }
+    return nil
+}
+
+// The binpkgs were written straight into the bind-mounted repository
+func (container *Container) Collect(job Job) error {
+    return nil
+}
+
+// The container (and masterdir) is removed by the runtime
+func (container *Container) Cleanup(job Job) error {
+    return nil
+}
@@ -0,0 +1,204 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package build
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "github.com/fosslinux/vxb/util"
+    "bytes"
+    "errors"
+    "io/ioutil"
+    str "strings"
+    "testing"
+    "time"
+)
+
+// Create a stub container runtime
+// Every call is appended to the calls file, one line each. run runs body.
+func stubRuntime(t *testing.T, body string) (string, string) {
+    dir := t.TempDir()
+    calls := dir + "/calls"
+    script := "#!/bin/sh\necho \"$@\" >> '" + calls + "'\n" +
+        "case \"$1\" in\n" +
+        "    run) " + body + " ;;\n" +
+        "esac\n"
+    err := ioutil.WriteFile(dir + "/runtime", []byte(script), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return dir + "/runtime", calls
+}
+
+// The calls made to a stub runtime
+func runtimeCalls(t *testing.T, calls string) []string {
+    data, err := ioutil.ReadFile(calls)
+    if err != nil {
+        return nil
+    }
+    return str.Split(str.TrimSpace(string(data[:])), "\n")
+}
+
+// Configuration of a container executor using runtime
+func containerCfg(runtime string) cfg.Cfgs {
+    return cfg.Cfgs{
+        VpkgPath: "/vpkgs",
+        HostArch: "x86_64",
+        ContainerRuntime: runtime,
+        ContainerImage: "voidlinux/masterdir",
+        ContainerArgs: []string{"--network", "host"},
+        MountSize: map[string]string{"tmpfs": "4G"},
+    }
+}
+
+func TestContainerRunArgs(t *testing.T) {
+    tests := []struct {
+        name string
+        job Job
+        // Expected somewhere in the arguments
+        want []string
+        // Not expected anywhere in the arguments
+        notWant []string
+    }{
+        {"native", Job{Pkgname: "foo", Arch: "x86_64", MountType: "none"},
+            []string{"-v /vpkgs:/void-packages", "-v /vpkgs/hostdir/binpkgs:/void-packages/hostdir/binpkgs",
+                "--network host voidlinux/masterdir sh -c",
+                "./xbps-src -m /masterdir binary-bootstrap x86_64 && ./xbps-src -m /masterdir pkg -N foo"},
+            []string{"--tmpfs", "-a "}},
+        {"cross on tmpfs", Job{Pkgname: "foo", Arch: "aarch64", MountType: "tmpfs"},
+            []string{"--tmpfs /masterdir:exec,size=4G",
+                "./xbps-src -a aarch64 -m /masterdir pkg -N foo"},
+            nil},
+        {"forced", Job{Pkgname: "foo", Arch: "x86_64", MountType: "none", Force: true},
+            []string{"pkg -N -f foo"}, nil},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            container := &Container{cfg: containerCfg("docker"), name: "vxb-test"}
+            command := container.Command(test.job)
+            if command[0] != "docker" {
+                t.Errorf("runtime is %s", command[0])
+            }
+            args := str.Join(command[1:], " ")
+            if !str.HasPrefix(args, "run --rm --name vxb-test ") {
+                t.Errorf("arguments start %q", args)
+            }
+            for _, want := range test.want {
+                if !str.Contains(args, want) {
+                    t.Errorf("%q not in %q", want, args)
+                }
+            }
+            for _, notWant := range test.notWant {
+                if str.Contains(args, notWant) {
+                    t.Errorf("%q in %q", notWant, args)
+                }
+            }
+        })
+    }
+}
+
+func TestContainerRun(t *testing.T) {
+    tests := []struct {
+        name string
+        // What the stub runtime does for run
+        body string
+        timeout time.Duration
+        wantErr bool
+        wantTimeout bool
+        wantOutput string
+    }{
+        {"success", "echo building; echo oops >&2", 0, false, false, "building\noops\n"},
+        {"failure", "echo broken; exit 2", 0, true, false, "broken\n"},
+        {"timeout", "echo slow; sleep 10", 200 * time.Millisecond, true, true, "slow\n"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            runtime, calls := stubRuntime(t, test.body)
+            containerCfg := containerCfg(runtime)
+            containerCfg.TimeoutDefault = test.timeout
+            container := &Container{cfg: containerCfg}
+            job := Job{Pkgname: "foo", Arch: "x86_64", MountType: "none"}
+
+            err := container.Prepare(job)
+            if err != nil {
+                t.Fatal(err)
+            }
+            var logs bytes.Buffer
+            err = container.Run(job, &logs)
+            if (err != nil) != test.wantErr {
+                t.Errorf("error is %v", err)
+            }
+            if errors.Is(err, util.ErrTimeout) != test.wantTimeout {
+                t.Errorf("timed out error is %v", err)
+            }
+            if logs.String() != test.wantOutput {
+                t.Errorf("output is %q, want %q", logs.String(), test.wantOutput)
+            }
+            err = container.Collect(job)
+            if err != nil {
+                t.Errorf("collecting gave %v", err)
+            }
+            err = container.Cleanup(job)
+            if err != nil {
+                t.Errorf("cleaning up gave %v", err)
+            }
+
+            // The image is checked, then the container run (and killed on
+            // a timeout)
+            got := runtimeCalls(t, calls)
+            want := []string{"image inspect voidlinux/masterdir", "run --rm --name " + container.name}
+            if test.wantTimeout {
+                want = append(want, "kill " + container.name)
+            }
+            if len(got) != len(want) {
+                t.Fatalf("calls are %q, want %q", got, want)
+            }
+            for i := range want {
+                if !str.HasPrefix(got[i], want[i]) {
+                    t.Errorf("call %d is %q, want %q", i, got[i], want[i])
+                }
+            }
+        })
+    }
+}
+
+func TestContainerPrepareMissingImage(t *testing.T) {
+    dir := t.TempDir()
+    err := ioutil.WriteFile(dir + "/runtime", []byte("#!/bin/sh\necho 'No such image' >&2\nexit 1\n"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    container := &Container{cfg: containerCfg(dir + "/runtime")}
+    err = container.Prepare(Job{Pkgname: "foo", Arch: "x86_64", MountType: "none"})
+    if err == nil {
+        t.Errorf("preparing without the image succeeded")
+    }
+}
+
+func TestContainerRunTimeout(t *testing.T) {
+    runtime, calls := stubRuntime(t, "sleep 60")
+    containerCfg := containerCfg(runtime)
+    containerCfg.TimeoutDefault = 200 * time.Millisecond
+    container := &Container{cfg: containerCfg}
+    job := Job{Pkgname: "foo", Arch: "x86_64", MountType: "none"}
+
+    err := container.Prepare(job)
+    if err != nil {
+        t.Fatal(err)
+    }
+    start := time.Now()
+    err = container.Run(job, ioutil.Discard)
+    if took := time.Since(start); took > 5 * time.Second {
+        t.Errorf("took %s to time out", took)
+    }
+    if !errors.Is(err, util.ErrTimeout) {
+        t.Errorf("error is %v, want a timeout", err)
+    }
+
+    // The container itself is killed through the runtime
+    got := runtimeCalls(t, calls)
+    if last := got[len(got) - 1]; last != "kill " + container.name {
+        t.Errorf("last call is %q, want the container killed", last)
+    }
+}
@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package build
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "fmt"
+    "io"
+    "net/http"
+)
+
+// A single package to build
+type Job struct {
+    // Base package name
+    Pkgname string
+    // Arch to build for
+    Arch string
+    // Mount type of the masterdir (see cfg.MountPkgs)
+    MountType string
+    // Build even if it is already in the repository
+    Force bool
+}
+
+// Something that can build packages
+// Each executor builds one package at a time; more are created to build
+// several at once.
+type Executor interface {
+    // Prepare somewhere to build the package (e.g. a masterdir)
+    Prepare(job Job) error
+    // The command Run runs, for the logs
+    Command(job Job) []string
+    // Build the package, streaming its output to logs
+    Run(job Job, logs io.Writer) error
+    // Put the built binpkgs into the local repository
+    Collect(job Job) error
+    // Clean up after a build, whether or not it succeeded
+    Cleanup(job Job) error
+}
+
+// xbps-src command to build a job
+func (job Job) pkgArgs() string {
+    if job.Force {
+        return "pkg -N -f " + job.Pkgname
+    }
+    return "pkg -N " + job.Pkgname
+}
+
+// Executors that can be chosen in the configuration
+var Executors = []string{"local", "container", "nomad", "remote"}
+
+// Create the executor chosen in the configuration
+// cfg is that of the worker the executor belongs to.
+func NewExecutor(cfg cfg.Cfgs) (Executor, error) {
+    switch cfg.Executor {
+        case "local":
+            return &Local{cfg: cfg}, nil
+        case "container":
+            return &Container{cfg: cfg}, nil
+        case "nomad":
+            return &Nomad{cfg: cfg, client: &http.Client{}}, nil
+        case "remote":
+            coord, err := getCoordinator(cfg)
+            if err != nil {
+                return nil, err
+            }
+            return &Remote{coord: coord}, nil
+    }
+    return nil, fmt.Errorf("Unknown executor %s", cfg.Executor)
+}
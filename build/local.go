@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package build
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "github.com/fosslinux/vxb/vpkgs"
+    "io"
+)
+
+// Build packages with xbps-src in a masterdir on this machine
+type Local struct {
+    cfg cfg.Cfgs
+}
+
+// Create (binary-bootstrap) the masterdir
+func (local *Local) Prepare(job Job) error {
+    return vpkgs.CreateMasterdir(job.MountType, local.cfg)
+}
+
+// The xbps-src command building the package
+func (local *Local) Command(job Job) []string {
+    masterdir := ""
+    if local.cfg.Masterdir != "masterdir" {
+        masterdir = vpkgs.MasterdirPath(local.cfg)
+    }
+    return append([]string{"./xbps-src"}, vpkgs.XbpsSrcArgs(job.pkgArgs(), job.Arch, masterdir, local.cfg)...)
+}
+
+// Build the package with xbps-src
+func (local *Local) Run(job Job, logs io.Writer) error {
+    return vpkgs.XbpsSrcStream(job.pkgArgs(), job.Arch, local.cfg.Timeout(job.Pkgname), logs, logs, local.cfg)
+}
+
+// xbps-src already put the binpkgs into the repository
+func (local *Local) Collect(job Job) error {
+    return nil
+}
+
+// Remove the masterdir
+func (local *Local) Cleanup(job Job) error {
+    if !vpkgs.MasterdirExists(local.cfg) {
+        return nil
+    }
+    return vpkgs.RemoveMasterdir(local.cfg)
+}
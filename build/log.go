@@ -0,0 +1,252 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package build
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "sort"
+    "strconv"
+    str "strings"
+    "time"
+)
+
+// Log files of one run of vxb, in <log dir>/<run id>/
+type RunLogs struct {
+    // Empty if logs are not being written to files
+    dir string
+}
+
+// Format of run ids, which are when the run started
+// Runs started in the same second get a .N suffix.
+const runIDFormat = "20060102-150405"
+
+// Check if a directory name is a run id
+func isRunID(name string) bool {
+    if i := str.Index(name, "."); i >= 0 {
+        _, err := strconv.ParseUint(name[i + 1:], 10, 0)
+        if err != nil {
+            return false
+        }
+        name = name[:i]
+    }
+    _, err := time.Parse(runIDFormat, name)
+    return err == nil
+}
+
+// Create the log directory of this run, removing the oldest runs so that
+// only cfg.LogKeep are kept
+func NewRunLogs(cfg cfg.Cfgs) (*RunLogs, error) {
+    if cfg.LogDir == "" {
+        return &RunLogs{}, nil
+    }
+
+    err := os.MkdirAll(cfg.LogDir, 0755)
+    if err != nil {
+        return nil, fmt.Errorf("Unable to create %s with %w", cfg.LogDir, err)
+    }
+
+    // The run id is when we started, which also sorts the runs
+    runID := time.Now().Format(runIDFormat)
+    dir := cfg.LogDir + "/" + runID
+    for i := 1; ; i++ {
+        err = os.Mkdir(dir, 0755)
+        if !os.IsExist(err) {
+            break
+        }
+        dir = fmt.Sprintf("%s/%s.%d", cfg.LogDir, runID, i)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("Unable to create %s with %w", dir, err)
+    }
+
+    err = pruneRuns(cfg.LogDir, cfg.LogKeep)
+    if err != nil {
+        return nil, err
+    }
+    return &RunLogs{dir: dir}, nil
+}
+
+// Remove all but the newest keep runs (0 keeps everything)
+// Anything else in logDir is left alone.
+func pruneRuns(logDir string, keep int) error {
+    if keep < 1 {
+        return nil
+    }
+
+    entries, err := ioutil.ReadDir(logDir)
+    if err != nil {
+        return fmt.Errorf("Error %w listing %s", err, logDir)
+    }
+    var runs []string
+    for _, entry := range entries {
+        if entry.IsDir() && isRunID(entry.Name()) {
+            runs = append(runs, entry.Name())
+        }
+    }
+    sort.Strings(runs)
+
+    for len(runs) > keep {
+        err = os.RemoveAll(logDir + "/" + runs[0])
+        if err != nil {
+            return fmt.Errorf("Error %w removing old logs %s", err, runs[0])
+        }
+        runs = runs[1:]
+    }
+    return nil
+}
+
+// Open the log of a package
+// Output is also written to out.
+func (runLogs *RunLogs) Open(ident string, out io.Writer) (*Log, error) {
+    if runLogs.dir == "" {
+        return NewLog(ident, "", out)
+    }
+    return NewLog(ident, runLogs.dir + "/" + ident + ".log", out)
+}
+
+// One attempt at a build
+type Attempt struct {
+    MountType string
+    Command []string
+    Started time.Time
+    Finished time.Time
+    // Exit status of the command, or the error if it didn't get that far
+    Status string
+    // nil if the attempt succeeded
+    Err error
+}
+
+// The output of a package build
+// While building, output goes to <path>.part. Once closed, the log is
+// written to path with a header describing each attempt.
+type Log struct {
+    ident string
+    path string
+    out io.Writer
+    part *os.File
+    attempts []Attempt
+}
+
+// Create the log of a package
+// If path is empty, output only goes to out.
+func NewLog(ident string, path string, out io.Writer) (*Log, error) {
+    log := &Log{ident: ident, path: path, out: out}
+    if path == "" {
+        return log, nil
+    }
+
+    part, err := os.Create(path + ".part")
+    if err != nil {
+        return nil, fmt.Errorf("Error %w creating %s", err, path + ".part")
+    }
+    log.part = part
+    return log, nil
+}
+
+// Path of the log file, empty if there is none
+func (log *Log) Path() string {
+    return log.path
+}
+
+// Write build output
+func (log *Log) Write(p []byte) (int, error) {
+    if log.part != nil {
+        _, err := log.part.Write(p)
+        if err != nil {
+            return 0, fmt.Errorf("Error %w writing %s", err, log.path + ".part")
+        }
+    }
+    return log.out.Write(p)
+}
+
+// Start an attempt at building
+func (log *Log) startAttempt(mountType string) {
+    log.attempts = append(log.attempts, Attempt{MountType: mountType, Started: time.Now()})
+}
+
+// Record the command run by the current attempt
+func (log *Log) attemptCommand(command []string) {
+    if len(log.attempts) == 0 {
+        return
+    }
+    log.attempts[len(log.attempts) - 1].Command = command
+}
+
+// Exit status of a command from its error
+func exitStatus(err error) string {
+    var exitErr *exec.ExitError
+    if err == nil {
+        return "0"
+    } else if errors.As(err, &exitErr) {
+        return fmt.Sprintf("%d", exitErr.ExitCode())
+    }
+    return err.Error()
+}
+
+// Finish the current attempt at building
+func (log *Log) finishAttempt(err error) {
+    if len(log.attempts) == 0 {
+        return
+    }
+    attempt := &log.attempts[len(log.attempts) - 1]
+    attempt.Finished = time.Now()
+    attempt.Status = exitStatus(err)
+    attempt.Err = err
+}
+
+// The attempts at building so far
+func (log *Log) Attempts() []Attempt {
+    return log.attempts
+}
+
+// Write the header and output to the log file
+func (log *Log) Close() error {
+    if log.part == nil {
+        return nil
+    }
+    partPath := log.path + ".part"
+    defer os.Remove(partPath)
+
+    _, err := log.part.Seek(0, io.SeekStart)
+    if err != nil {
+        log.part.Close()
+        return fmt.Errorf("Error %w rewinding %s", err, partPath)
+    }
+    defer log.part.Close()
+
+    f, err := os.Create(log.path)
+    if err != nil {
+        return fmt.Errorf("Error %w creating %s", err, log.path)
+    }
+
+    var sb str.Builder
+    sb.WriteString(fmt.Sprintf("# vxb build log of %s\n", log.ident))
+    for i, attempt := range log.attempts {
+        sb.WriteString(fmt.Sprintf("# attempt %d:\n", i + 1))
+        sb.WriteString(fmt.Sprintf("#   started: %s\n", attempt.Started.Format(time.RFC3339)))
+        sb.WriteString(fmt.Sprintf("#   finished: %s (%s)\n", attempt.Finished.Format(time.RFC3339),
+            attempt.Finished.Sub(attempt.Started).Round(time.Second)))
+        sb.WriteString(fmt.Sprintf("#   command: %s\n", str.Join(attempt.Command, " ")))
+        sb.WriteString(fmt.Sprintf("#   mount type: %s\n", attempt.MountType))
+        sb.WriteString(fmt.Sprintf("#   exit status: %s\n", attempt.Status))
+    }
+    sb.WriteString("\n")
+
+    _, err = f.WriteString(sb.String())
+    if err == nil {
+        _, err = io.Copy(f, log.part)
+    }
+    if err != nil {
+        f.Close()
+        return fmt.Errorf("Error %w writing %s", err, log.path)
+    }
+    return f.Close()
+}
@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package build
+
+import (
+    "io/ioutil"
+    "os"
+    "reflect"
+    "testing"
+)
+
+func TestPruneRuns(t *testing.T) {
+    logDir := t.TempDir()
+    for _, name := range []string{"20210101-120000", "20210102-120000", "20210102-120000.1",
+        "20210103-120000", "backups", "2021", "20210101-120000.x", "00000000-000000"} {
+        err := os.Mkdir(logDir + "/" + name, 0755)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    // Files are never runs
+    err := ioutil.WriteFile(logDir + "/20200101-120000", nil, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = pruneRuns(logDir, 2)
+    if err != nil {
+        t.Fatal(err)
+    }
+    entries, err := ioutil.ReadDir(logDir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var names []string
+    for _, entry := range entries {
+        names = append(names, entry.Name())
+    }
+    want := []string{"00000000-000000", "20200101-120000", "2021", "20210101-120000.x",
+        "20210102-120000.1", "20210103-120000", "backups"}
+    if !reflect.DeepEqual(names, want) {
+        t.Errorf("left %v, want %v", names, want)
+    }
+}
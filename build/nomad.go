@@ -0,0 +1,306 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package build
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "github.com/fosslinux/vxb/util"
+    "github.com/fosslinux/vxb/vpkgs"
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "sort"
+    str "strings"
+    "time"
+)
+
+// How often to check on a running Nomad job
+var nomadPollInterval = 2 * time.Second
+
+// Name of the one task in each Nomad job
+const nomadTask = "xbps-src"
+
+// Build packages as Nomad batch jobs
+// The Nomad clients run xbps-src with the raw_exec driver, so they must have
+// void-packages (at NomadVpkgPath) and share its hostdir/binpkgs with us.
+type Nomad struct {
+    cfg cfg.Cfgs
+    client *http.Client
+    // ID of the job currently being built
+    jobID string
+}
+
+// The parts of a Nomad allocation we care about
+type nomadAlloc struct {
+    ID string
+    ClientStatus string
+    TaskStates map[string]struct {
+        State string
+        Failed bool
+    }
+}
+
+// The parts of a Nomad evaluation we care about
+type nomadEval struct {
+    ID string
+    Status string
+    // Task groups that could not be placed, and why
+    FailedTGAllocs map[string]struct {
+        NodesEvaluated int
+        NodesFiltered int
+        NodesExhausted int
+        ConstraintFiltered map[string]int
+        DimensionExhausted map[string]int
+    }
+}
+
+// The parts of a Nomad job we care about
+type nomadJobStatus struct {
+    Status string
+}
+
+// Make a request to the Nomad HTTP API
+// If out is not nil, the response is decoded into it.
+func (nomad *Nomad) request(method string, path string, in interface{}, out interface{}) error {
+    var body io.Reader
+    if in != nil {
+        data, err := json.Marshal(in)
+        if err != nil {
+            return fmt.Errorf("Error %w encoding request to %s", err, path)
+        }
+        body = bytes.NewReader(data)
+    }
+
+    req, err := http.NewRequest(method, str.TrimSuffix(nomad.cfg.NomadAddress, "/") + path, body)
+    if err != nil {
+        return fmt.Errorf("Error %w creating request to %s", err, path)
+    }
+    if nomad.cfg.NomadToken != "" {
+        req.Header.Set("X-Nomad-Token", nomad.cfg.NomadToken)
+    }
+    resp, err := nomad.client.Do(req)
+    if err != nil {
+        return fmt.Errorf("Error %w requesting %s %s", err, method, path)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        msg, _ := ioutil.ReadAll(resp.Body)
+        return fmt.Errorf("Nomad returned %s for %s %s: %s", resp.Status, method, path, str.TrimSpace(string(msg[:])))
+    }
+    if out == nil {
+        return nil
+    }
+    err = json.NewDecoder(resp.Body).Decode(out)
+    if err != nil {
+        return fmt.Errorf("Error %w decoding response from %s", err, path)
+    }
+    return nil
+}
+
+// Shell script run by the job to build a package in its own masterdir
+func (nomad *Nomad) script(job Job) string {
+    cfg := nomad.cfg
+    masterdir := "masterdir-" + nomad.jobID
+
+    bootstrap := vpkgs.XbpsSrcArgs("binary-bootstrap " + cfg.HostArch, cfg.HostArch, masterdir, cfg)
+    pkg := vpkgs.XbpsSrcArgs(job.pkgArgs(), job.Arch, masterdir, cfg)
+
+    vpkgPath := cfg.NomadVpkgPath
+    if vpkgPath == "" {
+        vpkgPath = cfg.VpkgPath
+    }
+
+    return fmt.Sprintf("cd '%s' && ./xbps-src %s && ./xbps-src %s; rc=$?; rm -rf '%s'; exit $rc",
+        vpkgPath, str.Join(bootstrap, " "), str.Join(pkg, " "), masterdir)
+}
+
+// The command run by the Nomad job
+func (nomad *Nomad) Command(job Job) []string {
+    return []string{"/bin/sh", "-c", nomad.script(job)}
+}
+
+// Nomad jobs need nothing set up here
+func (nomad *Nomad) Prepare(job Job) error {
+    nomad.jobID = fmt.Sprintf("vxb-%s-%s-%d", job.Pkgname, job.Arch, time.Now().UnixNano())
+    return nil
+}
+
+// Submit the build as a batch job and follow it until it finishes
+func (nomad *Nomad) Run(job Job, logs io.Writer) error {
+    cfg := nomad.cfg
+
+    // Never retry or reschedule, a failure is a failure
+    nomadJob := map[string]interface{}{
+        "ID": nomad.jobID,
+        "Name": nomad.jobID,
+        "Type": "batch",
+        "Datacenters": []string{cfg.NomadDatacenter},
+        "TaskGroups": []interface{}{map[string]interface{}{
+            "Name": "build",
+            "Count": 1,
+            "RestartPolicy": map[string]interface{}{"Attempts": 0, "Mode": "fail"},
+            "ReschedulePolicy": map[string]interface{}{"Attempts": 0, "Unlimited": false},
+            "Tasks": []interface{}{map[string]interface{}{
+                "Name": nomadTask,
+                "Driver": "raw_exec",
+                "Config": map[string]interface{}{
+                    "command": "/bin/sh",
+                    "args": nomad.Command(job)[1:],
+                },
+                "Resources": map[string]interface{}{
+                    "CPU": cfg.NomadCPU,
+                    "MemoryMB": cfg.NomadMemory,
+                },
+            }},
+        }},
+    }
+    err := nomad.request("PUT", "/v1/jobs", map[string]interface{}{"Job": nomadJob}, nil)
+    if err != nil {
+        return err
+    }
+
+    // Wait for the allocation to finish, passing on its logs as we go
+    // On a timeout, Cleanup stops the job.
+    offsets := map[string]int64{"stdout": 0, "stderr": 0}
+    timeout := cfg.Timeout(job.Pkgname)
+    start := time.Now()
+    for {
+        if timeout > 0 && time.Since(start) > timeout {
+            return fmt.Errorf("Nomad job %s %w after %s", nomad.jobID, util.ErrTimeout, timeout)
+        }
+
+        var allocs []nomadAlloc
+        err = nomad.request("GET", "/v1/job/" + url.PathEscape(nomad.jobID) + "/allocations", nil, &allocs)
+        if err != nil {
+            return err
+        }
+
+        if len(allocs) == 0 {
+            // Nothing is running yet, maybe because nothing can run it
+            err = nomad.checkPlaceable()
+            if err != nil {
+                return err
+            }
+        } else {
+            alloc := allocs[0]
+            if alloc.ClientStatus != "pending" {
+                for _, logType := range []string{"stdout", "stderr"} {
+                    err = nomad.copyLogs(alloc.ID, logType, offsets, logs)
+                    if err != nil {
+                        return err
+                    }
+                }
+            }
+
+            switch alloc.ClientStatus {
+                case "complete":
+                    if alloc.TaskStates[nomadTask].Failed {
+                        return fmt.Errorf("Nomad job %s failed", nomad.jobID)
+                    }
+                    return nil
+                case "failed", "lost":
+                    return fmt.Errorf("Nomad job %s %s", nomad.jobID, alloc.ClientStatus)
+            }
+        }
+
+        time.Sleep(nomadPollInterval)
+    }
+}
+
+// Check that the job has been, or can still be, placed on a client
+// A job that can't be placed (no eligible client, wrong datacenter, etc) is
+// blocked rather than failed by Nomad, so would otherwise be waited on
+// forever. A job that is only waiting for capacity (e.g. our other builds)
+// is blocked the same way, but will be placed once that frees up, so is
+// left to wait (at most its timeout).
+func (nomad *Nomad) checkPlaceable() error {
+    var evals []nomadEval
+    err := nomad.request("GET", "/v1/job/" + url.PathEscape(nomad.jobID) + "/evaluations", nil, &evals)
+    if err != nil {
+        return err
+    }
+    for _, eval := range evals {
+        for group, metrics := range eval.FailedTGAllocs {
+            // Some client could run it, if it weren't busy
+            if metrics.NodesExhausted > 0 || len(metrics.DimensionExhausted) > 0 {
+                continue
+            }
+            reasons := []string{fmt.Sprintf("%d node(s) evaluated", metrics.NodesEvaluated)}
+            for constraint, n := range metrics.ConstraintFiltered {
+                reasons = append(reasons, fmt.Sprintf("%d filtered by %s", n, constraint))
+            }
+            sort.Strings(reasons[1:])
+            return fmt.Errorf("Nomad job %s cannot be placed (task group %s, %s)", nomad.jobID, group,
+                str.Join(reasons, ", "))
+        }
+    }
+
+    var job nomadJobStatus
+    err = nomad.request("GET", "/v1/job/" + url.PathEscape(nomad.jobID), nil, &job)
+    if err != nil {
+        return err
+    }
+    if job.Status == "dead" {
+        return fmt.Errorf("Nomad job %s is dead without having run", nomad.jobID)
+    }
+    return nil
+}
+
+// Write the logs of an allocation we haven't seen yet
+func (nomad *Nomad) copyLogs(allocID string, logType string, offsets map[string]int64, logs io.Writer) error {
+    query := url.Values{}
+    query.Set("task", nomadTask)
+    query.Set("type", logType)
+    query.Set("origin", "start")
+    query.Set("offset", fmt.Sprintf("%d", offsets[logType]))
+    query.Set("plain", "true")
+
+    req, err := http.NewRequest("GET", str.TrimSuffix(nomad.cfg.NomadAddress, "/") +
+        "/v1/client/fs/logs/" + url.PathEscape(allocID) + "?" + query.Encode(), nil)
+    if err != nil {
+        return fmt.Errorf("Error %w creating request for logs of %s", err, allocID)
+    }
+    if nomad.cfg.NomadToken != "" {
+        req.Header.Set("X-Nomad-Token", nomad.cfg.NomadToken)
+    }
+    resp, err := nomad.client.Do(req)
+    if err != nil {
+        return fmt.Errorf("Error %w getting logs of %s", err, allocID)
+    }
+    defer resp.Body.Close()
+    // The logs may not exist until the task has started
+    if resp.StatusCode == http.StatusNotFound {
+        return nil
+    } else if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("Nomad returned %s for logs of %s", resp.Status, allocID)
+    }
+
+    n, err := io.Copy(logs, resp.Body)
+    offsets[logType] += n
+    if err != nil {
+        return fmt.Errorf("Error %w copying logs of %s", err, allocID)
+    }
+    return nil
+}
+
+// The binpkgs were written to the shared repository by the client
+func (nomad *Nomad) Collect(job Job) error {
+    return nil
+}
+
+// Remove the job from Nomad
+func (nomad *Nomad) Cleanup(job Job) error {
+    if nomad.jobID == "" {
+        return nil
+    }
+    err := nomad.request("DELETE", "/v1/job/" + url.PathEscape(nomad.jobID) + "?purge=true", nil, nil)
+    nomad.jobID = ""
+    return err
+}
@@ -0,0 +1,191 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package build
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "github.com/fosslinux/vxb/util"
+    "bytes"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    str "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// A fake Nomad agent running a single job
+type fakeNomad struct {
+    mutex sync.Mutex
+    // Allocations returned once the job has been polled this many times
+    pendingPolls int
+    polls int
+    allocs []nomadAlloc
+    evals []nomadEval
+    jobStatus string
+    stdout string
+    // Requests made, as "METHOD path"
+    requests []string
+}
+
+// Serve the parts of the Nomad HTTP API we use
+func (fake *fakeNomad) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    fake.mutex.Lock()
+    defer fake.mutex.Unlock()
+    fake.requests = append(fake.requests, r.Method + " " + r.URL.Path)
+
+    reply := func(v interface{}) {
+        json.NewEncoder(w).Encode(v)
+    }
+    switch {
+        case r.Method == "PUT" && r.URL.Path == "/v1/jobs":
+            reply(map[string]string{"EvalID": "eval"})
+        case r.Method == "DELETE" && str.HasPrefix(r.URL.Path, "/v1/job/"):
+            reply(map[string]string{"EvalID": "eval"})
+        case str.HasSuffix(r.URL.Path, "/allocations"):
+            fake.polls++
+            if fake.polls <= fake.pendingPolls {
+                reply([]nomadAlloc{})
+            } else {
+                reply(fake.allocs)
+            }
+        case str.HasSuffix(r.URL.Path, "/evaluations"):
+            reply(fake.evals)
+        case str.HasPrefix(r.URL.Path, "/v1/client/fs/logs/"):
+            if r.URL.Query().Get("type") != "stdout" {
+                return
+            }
+            offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+            w.Write([]byte(fake.stdout[offset:]))
+        case str.HasPrefix(r.URL.Path, "/v1/job/"):
+            reply(map[string]string{"Status": fake.jobStatus})
+        default:
+            http.NotFound(w, r)
+    }
+}
+
+// An allocation with the given status
+func testAlloc(clientStatus string, failed bool) nomadAlloc {
+    alloc := nomadAlloc{ID: "alloc", ClientStatus: clientStatus}
+    alloc.TaskStates = map[string]struct {
+        State string
+        Failed bool
+    }{nomadTask: {State: "dead", Failed: failed}}
+    return alloc
+}
+
+// The metrics of a task group that could not be placed
+type testTGMetrics = struct {
+    NodesEvaluated int
+    NodesFiltered int
+    NodesExhausted int
+    ConstraintFiltered map[string]int
+    DimensionExhausted map[string]int
+}
+
+// An evaluation that could not place the build task group
+func unplacedEval(metrics testTGMetrics) nomadEval {
+    eval := nomadEval{ID: "eval", Status: "complete"}
+    eval.FailedTGAllocs = map[string]testTGMetrics{"build": metrics}
+    return eval
+}
+
+// An evaluation that could not place the build task group on any client
+func filteredEval() nomadEval {
+    return unplacedEval(testTGMetrics{NodesEvaluated: 2, NodesFiltered: 2,
+        ConstraintFiltered: map[string]int{"missing drivers": 2}})
+}
+
+// An evaluation that could place the build task group, but every client is busy
+func exhaustedEval() nomadEval {
+    return unplacedEval(testTGMetrics{NodesEvaluated: 2, NodesFiltered: 1, NodesExhausted: 1,
+        ConstraintFiltered: map[string]int{"missing drivers": 1},
+        DimensionExhausted: map[string]int{"memory": 1}})
+}
+
+func TestNomadRun(t *testing.T) {
+    tests := []struct {
+        name string
+        fake *fakeNomad
+        timeout time.Duration
+        // Empty if the build succeeds
+        wantErr string
+        wantTimeout bool
+        wantOutput string
+    }{
+        {"success", &fakeNomad{pendingPolls: 2, allocs: []nomadAlloc{testAlloc("complete", false)},
+            jobStatus: "running", stdout: "building\n"}, 0, "", false, "building\n"},
+        {"task failed", &fakeNomad{allocs: []nomadAlloc{testAlloc("complete", true)},
+            jobStatus: "dead", stdout: "broken\n"}, 0, "failed", false, "broken\n"},
+        {"allocation lost", &fakeNomad{allocs: []nomadAlloc{testAlloc("lost", false)},
+            jobStatus: "dead"}, 0, "lost", false, ""},
+        {"cannot be placed", &fakeNomad{pendingPolls: 1000, evals: []nomadEval{filteredEval()},
+            jobStatus: "pending"}, 0, "cannot be placed (task group build, 2 node(s) evaluated, " +
+            "2 filtered by missing drivers)", false, ""},
+        {"no nodes in the datacenter", &fakeNomad{pendingPolls: 1000, evals: []nomadEval{unplacedEval(testTGMetrics{})},
+            jobStatus: "pending"}, 0, "cannot be placed (task group build, 0 node(s) evaluated)", false, ""},
+        {"waiting for capacity", &fakeNomad{pendingPolls: 5, evals: []nomadEval{exhaustedEval()},
+            allocs: []nomadAlloc{testAlloc("complete", false)}, jobStatus: "pending", stdout: "building\n"},
+            0, "", false, "building\n"},
+        {"waiting for capacity until the timeout", &fakeNomad{pendingPolls: 1000, evals: []nomadEval{exhaustedEval()},
+            jobStatus: "pending"}, 100 * time.Millisecond, "timed out", true, ""},
+        {"dead without allocations", &fakeNomad{pendingPolls: 1000, jobStatus: "dead"}, 0,
+            "dead without having run", false, ""},
+        {"timeout", &fakeNomad{pendingPolls: 1000, jobStatus: "pending"}, 100 * time.Millisecond,
+            "timed out", true, ""},
+    }
+    nomadPollInterval = 10 * time.Millisecond
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            fake := test.fake
+            server := httptest.NewServer(fake)
+            defer server.Close()
+
+            nomad := &Nomad{cfg: cfg.Cfgs{
+                VpkgPath: "/vpkgs",
+                HostArch: "x86_64",
+                NomadAddress: server.URL,
+                NomadDatacenter: "dc1",
+                TimeoutDefault: test.timeout,
+            }, client: server.Client()}
+            job := Job{Pkgname: "foo", Arch: "x86_64", MountType: "none"}
+
+            err := nomad.Prepare(job)
+            if err != nil {
+                t.Fatal(err)
+            }
+            var logs bytes.Buffer
+            err = nomad.Run(job, &logs)
+            if test.wantErr == "" && err != nil {
+                t.Errorf("error is %v", err)
+            } else if test.wantErr != "" && (err == nil || !str.Contains(err.Error(), test.wantErr)) {
+                t.Errorf("error is %v, want %q", err, test.wantErr)
+            }
+            if errors.Is(err, util.ErrTimeout) != test.wantTimeout {
+                t.Errorf("timed out error is %v", err)
+            }
+            if logs.String() != test.wantOutput {
+                t.Errorf("output is %q, want %q", logs.String(), test.wantOutput)
+            }
+            err = nomad.Cleanup(job)
+            if err != nil {
+                t.Errorf("cleaning up gave %v", err)
+            }
+
+            // The job is registered first and purged last
+            fake.mutex.Lock()
+            defer fake.mutex.Unlock()
+            if fake.requests[0] != "PUT /v1/jobs" {
+                t.Errorf("first request is %q", fake.requests[0])
+            }
+            if last := fake.requests[len(fake.requests) - 1]; !str.HasPrefix(last, "DELETE /v1/job/vxb-foo-x86_64-") {
+                t.Errorf("last request is %q", last)
+            }
+        })
+    }
+}
@@ -0,0 +1,449 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package build
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "github.com/fosslinux/vxb/util"
+    "github.com/fosslinux/vxb/vpkgs"
+    "crypto/subtle"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "os"
+    "path/filepath"
+    str "strings"
+    "sync"
+    "time"
+)
+
+// How long a worker waits for a job before asking again
+var remotePollTimeout = 30 * time.Second
+
+// How long a worker may go without a heartbeat before its job is failed
+var remoteLease = 2 * time.Minute
+
+// How often a worker sends a heartbeat while building
+var remoteHeartbeatInterval = 30 * time.Second
+
+// How often a job waiting for a worker checks if any are still around
+var remoteWaitCheck = 10 * time.Second
+
+// A worker registering with the coordinator
+type RemoteWorker struct {
+    Name string
+    // Must be the same as the host arch of the coordinator
+    HostArch string
+}
+
+// Reply to a registration
+type RemoteRegistration struct {
+    ID string
+}
+
+// A job handed to a remote worker
+type RemoteJob struct {
+    ID string
+    Job Job
+    // Size of the masterdir mount, if any
+    MountSize string
+    // Subrepo of the arch, if any
+    SubRepo string
+    // How long the build may take (0 means forever)
+    Timeout time.Duration
+}
+
+// The result of a job, sent back by the worker
+type RemoteResult struct {
+    // Empty if the build succeeded
+    Error string
+    // The build failed because it took too long
+    TimedOut bool
+}
+
+// A job waiting for, or being built by, a worker
+type remoteTask struct {
+    RemoteJob
+    logs io.Writer
+    done chan error
+    // Told when the task has to be handed out again
+    requeued chan bool
+    once sync.Once
+    // Worker building the task (empty while pending) and when its lease
+    // runs out
+    worker string
+    lease time.Time
+}
+
+// Finish a task (only the first result counts)
+func (task *remoteTask) finish(err error) {
+    task.once.Do(func() {
+        task.done <- err
+    })
+}
+
+// Hands jobs out to remote workers and takes their results back
+type coordinator struct {
+    cfg cfg.Cfgs
+    mutex sync.Mutex
+    workers map[string]RemoteWorker
+    tasks map[string]*remoteTask
+    // Tasks not yet taken by a worker
+    pending chan *remoteTask
+    // When a worker was last heard from (or when we started listening)
+    seen time.Time
+    nextID int
+    // Only one binpkg is indexed at a time
+    indexMutex sync.Mutex
+}
+
+// There is one coordinator, shared by every Remote executor
+var remoteCoordinator *coordinator
+var remoteCoordinatorErr error
+var remoteCoordinatorOnce sync.Once
+
+// Get the coordinator, starting it if it is not yet running
+func getCoordinator(cfg cfg.Cfgs) (*coordinator, error) {
+    remoteCoordinatorOnce.Do(func() {
+        coord := &coordinator{cfg: cfg, seen: time.Now()}
+        coord.workers = make(map[string]RemoteWorker)
+        coord.tasks = make(map[string]*remoteTask)
+        coord.pending = make(chan *remoteTask)
+
+        listener, err := net.Listen("tcp", cfg.RemoteListen)
+        if err != nil {
+            remoteCoordinatorErr = fmt.Errorf("Error %w listening on %s", err, cfg.RemoteListen)
+            return
+        }
+        fmt.Printf("Waiting for remote workers on %s...\n", listener.Addr())
+        go http.Serve(listener, coord.handler())
+        go coord.watchLeases()
+        remoteCoordinator = coord
+    })
+    return remoteCoordinator, remoteCoordinatorErr
+}
+
+// Routes of the coordinator
+func (coord *coordinator) handler() http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/v1/register", coord.register)
+    mux.HandleFunc("/v1/job", coord.job)
+    mux.HandleFunc("/v1/job/", coord.jobAction)
+    mux.HandleFunc("/v1/binpkgs", coord.binpkgs)
+    mux.Handle("/v1/binpkgs/", http.StripPrefix("/v1/binpkgs/",
+        http.FileServer(http.Dir(coord.cfg.VpkgPath + "/hostdir/binpkgs"))))
+
+    // Everything needs the token, if there is one
+    // Workers poll for jobs and send heartbeats, so any request means one is
+    // still around.
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        token := coord.cfg.RemoteToken
+        if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Vxb-Token")), []byte(token)) != 1 {
+            http.Error(w, "bad token", http.StatusUnauthorized)
+            return
+        }
+        coord.mutex.Lock()
+        coord.seen = time.Now()
+        coord.mutex.Unlock()
+        mux.ServeHTTP(w, r)
+    })
+}
+
+// Reply with JSON
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+    w.Header().Set("Content-Type", "application/json")
+    return json.NewEncoder(w).Encode(v)
+}
+
+// Register a new worker
+func (coord *coordinator) register(w http.ResponseWriter, r *http.Request) {
+    var worker RemoteWorker
+    err := json.NewDecoder(r.Body).Decode(&worker)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    // Host dependencies are only built for our host arch
+    if worker.HostArch != coord.cfg.HostArch {
+        http.Error(w, fmt.Sprintf("host arch %s is not %s", worker.HostArch, coord.cfg.HostArch),
+            http.StatusBadRequest)
+        return
+    }
+
+    coord.mutex.Lock()
+    coord.nextID++
+    id := fmt.Sprintf("%s-%d", worker.Name, coord.nextID)
+    coord.workers[id] = worker
+    coord.mutex.Unlock()
+
+    fmt.Printf("Remote worker %s registered.\n", id)
+    writeJSON(w, RemoteRegistration{ID: id})
+}
+
+// Give a job to a worker, if there is one within remotePollTimeout
+func (coord *coordinator) job(w http.ResponseWriter, r *http.Request) {
+    workerID := r.URL.Query().Get("worker")
+    coord.mutex.Lock()
+    _, known := coord.workers[workerID]
+    coord.mutex.Unlock()
+    if !known {
+        http.Error(w, "unknown worker", http.StatusNotFound)
+        return
+    }
+
+    select {
+        case task := <-coord.pending:
+            coord.mutex.Lock()
+            task.worker = workerID
+            task.lease = time.Now().Add(remoteLease)
+            coord.mutex.Unlock()
+
+            err := writeJSON(w, task.RemoteJob)
+            if err != nil {
+                // The worker never got it, so someone else has to
+                fmt.Fprintf(os.Stderr, "ERROR: Unable to give job %s to %s: %s\n", task.ID, workerID, err)
+                coord.requeue(task)
+                return
+            }
+            fmt.Printf("Building %s@%s on %s...\n", task.Job.Pkgname, task.Job.Arch, workerID)
+        case <-time.After(remotePollTimeout):
+            w.WriteHeader(http.StatusNoContent)
+        case <-r.Context().Done():
+    }
+}
+
+// Logs, binpkgs and results of a job (/v1/job/<id>/<action>)
+func (coord *coordinator) jobAction(w http.ResponseWriter, r *http.Request) {
+    parts := str.Split(str.TrimPrefix(r.URL.Path, "/v1/job/"), "/")
+    if len(parts) != 2 || r.Method != "POST" {
+        http.NotFound(w, r)
+        return
+    }
+    coord.mutex.Lock()
+    task, exists := coord.tasks[parts[0]]
+    coord.mutex.Unlock()
+    if !exists {
+        http.Error(w, "unknown job", http.StatusNotFound)
+        return
+    }
+
+    switch parts[1] {
+        case "heartbeat":
+            coord.mutex.Lock()
+            task.lease = time.Now().Add(remoteLease)
+            coord.mutex.Unlock()
+        case "logs":
+            _, err := io.Copy(task.logs, r.Body)
+            if err != nil {
+                task.finish(fmt.Errorf("Lost logs of job %s with %w", task.ID, err))
+            }
+        case "binpkg":
+            err := coord.receiveBinpkg(task, r.URL.Query().Get("path"), r.Body)
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusInternalServerError)
+            }
+        case "result":
+            var result RemoteResult
+            err := json.NewDecoder(r.Body).Decode(&result)
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            if result.TimedOut {
+                task.finish(fmt.Errorf("%w: %s", util.ErrTimeout, result.Error))
+            } else if result.Error != "" {
+                task.finish(errors.New(result.Error))
+            } else {
+                task.finish(nil)
+            }
+        default:
+            http.NotFound(w, r)
+    }
+}
+
+// Put a task back to be handed to the next free worker
+func (coord *coordinator) requeue(task *remoteTask) {
+    coord.mutex.Lock()
+    task.worker = ""
+    coord.mutex.Unlock()
+    task.requeued <- true
+}
+
+// Fail the tasks of workers whose lease has run out
+// The worker may have died halfway through the build, so the task is failed
+// (and retried as any other failure would be) rather than handed out again.
+func (coord *coordinator) expireLeases(now time.Time) {
+    coord.mutex.Lock()
+    defer coord.mutex.Unlock()
+    for _, task := range coord.tasks {
+        if task.worker == "" || now.Before(task.lease) {
+            continue
+        }
+        task.finish(fmt.Errorf("Remote worker %s stopped responding while building job %s", task.worker,
+            task.ID))
+        task.worker = ""
+    }
+}
+
+// Check the leases of tasks being built, forever
+func (coord *coordinator) watchLeases() {
+    for now := range time.Tick(remoteLease / 4) {
+        coord.expireLeases(now)
+    }
+}
+
+// Check a path given by a worker stays within hostdir/binpkgs
+func binpkgPath(root string, rel string) (string, error) {
+    rel = filepath.Clean(rel)
+    if rel == "." || filepath.IsAbs(rel) || rel == ".." || str.HasPrefix(rel, "../") ||
+        !str.HasSuffix(rel, ".xbps") {
+        return "", fmt.Errorf("Bad binpkg path %s", rel)
+    }
+    return root + "/" + rel, nil
+}
+
+// Put a binpkg uploaded by a worker into our repository
+func (coord *coordinator) receiveBinpkg(task *remoteTask, rel string, body io.Reader) error {
+    path, err := binpkgPath(coord.cfg.VpkgPath + "/hostdir/binpkgs", rel)
+    if err != nil {
+        return err
+    }
+    err = os.MkdirAll(filepath.Dir(path), 0755)
+    if err != nil {
+        return fmt.Errorf("Unable to create %s with %w", filepath.Dir(path), err)
+    }
+
+    // Don't leave a half-written binpkg behind
+    out, err := os.Create(path + ".tmp")
+    if err != nil {
+        return fmt.Errorf("Error %w creating %s", err, path + ".tmp")
+    }
+    _, err = io.Copy(out, body)
+    if err != nil {
+        out.Close()
+        os.Remove(path + ".tmp")
+        return fmt.Errorf("Error %w receiving %s", err, rel)
+    }
+    err = out.Close()
+    if err != nil {
+        return fmt.Errorf("Error %w writing %s", err, path + ".tmp")
+    }
+    err = os.Rename(path + ".tmp", path)
+    if err != nil {
+        return fmt.Errorf("Error %w renaming %s", err, path + ".tmp")
+    }
+
+    // Several uploads may arrive at once
+    coord.indexMutex.Lock()
+    defer coord.indexMutex.Unlock()
+    return vpkgs.IndexBinpkgs([]string{path}, vpkgs.BinpkgArch(path, task.Job.Arch))
+}
+
+// List the binpkgs we have, so workers can fetch what they are missing
+func (coord *coordinator) binpkgs(w http.ResponseWriter, r *http.Request) {
+    binpkgs, err := vpkgs.ListBinpkgs(coord.cfg)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    writeJSON(w, binpkgs)
+}
+
+// Wait for a worker to take a task
+// The package's timeout applies to waiting too, and we give up if no worker
+// has been heard from in cfg.RemoteWait.
+func (coord *coordinator) handOut(task *remoteTask) error {
+    var timeout <-chan time.Time
+    if task.Timeout > 0 {
+        timer := time.NewTimer(task.Timeout)
+        defer timer.Stop()
+        timeout = timer.C
+    }
+    check := time.NewTicker(remoteWaitCheck)
+    defer check.Stop()
+
+    for {
+        select {
+            case coord.pending <- task:
+                return nil
+            case <-timeout:
+                return fmt.Errorf("Job %s %w after %s waiting for a remote worker", task.ID, util.ErrTimeout,
+                    task.Timeout)
+            case now := <-check.C:
+                coord.mutex.Lock()
+                seen := coord.seen
+                coord.mutex.Unlock()
+                if coord.cfg.RemoteWait > 0 && now.Sub(seen) > coord.cfg.RemoteWait {
+                    return fmt.Errorf("No remote worker has been heard from in %s, so job %s can't be built",
+                        coord.cfg.RemoteWait, task.ID)
+                }
+        }
+    }
+}
+
+// Hand a job out to the first free worker and wait for it to be built
+func (coord *coordinator) build(job Job, logs io.Writer) error {
+    coord.mutex.Lock()
+    coord.nextID++
+    task := &remoteTask{logs: logs, done: make(chan error, 1), requeued: make(chan bool, 1)}
+    task.ID = fmt.Sprintf("%s-%s-%d", job.Pkgname, job.Arch, coord.nextID)
+    task.Job = job
+    task.MountSize = coord.cfg.MountSize[job.MountType]
+    task.SubRepo = coord.cfg.SubRepos[job.Arch]
+    task.Timeout = coord.cfg.Timeout(job.Pkgname)
+    coord.tasks[task.ID] = task
+    coord.mutex.Unlock()
+
+    defer func() {
+        coord.mutex.Lock()
+        delete(coord.tasks, task.ID)
+        coord.mutex.Unlock()
+    }()
+
+    for {
+        err := coord.handOut(task)
+        if err != nil {
+            return err
+        }
+        select {
+            case err = <-task.done:
+                return err
+            case <-task.requeued:
+        }
+    }
+}
+
+// Build packages on whichever remote worker (see cmd/vxb-worker) is free
+type Remote struct {
+    coord *coordinator
+}
+
+// Remote workers prepare their own masterdirs
+func (remote *Remote) Prepare(job Job) error {
+    return nil
+}
+
+// The xbps-src command the worker runs (in its own default masterdir)
+func (remote *Remote) Command(job Job) []string {
+    return append([]string{"./xbps-src"}, vpkgs.XbpsSrcArgs(job.pkgArgs(), job.Arch, "", remote.coord.cfg)...)
+}
+
+// Wait for a worker to build the package
+func (remote *Remote) Run(job Job, logs io.Writer) error {
+    return remote.coord.build(job, logs)
+}
+
+// The worker uploaded the binpkgs while building
+func (remote *Remote) Collect(job Job) error {
+    return nil
+}
+
+// Remote workers clean up after themselves
+func (remote *Remote) Cleanup(job Job) error {
+    return nil
+}
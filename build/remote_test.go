@@ -0,0 +1,395 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package build
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "github.com/fosslinux/vxb/util"
+    "bytes"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    str "strings"
+    "testing"
+    "time"
+)
+
+// A coordinator that isn't listening, for calling handlers directly
+func testCoordinator(cfg cfg.Cfgs) *coordinator {
+    coord := &coordinator{cfg: cfg, seen: time.Now()}
+    coord.workers = make(map[string]RemoteWorker)
+    coord.tasks = make(map[string]*remoteTask)
+    coord.pending = make(chan *remoteTask)
+    return coord
+}
+
+// A task waiting to be built
+func testTask(coord *coordinator, id string) *remoteTask {
+    task := &remoteTask{logs: ioutil.Discard, done: make(chan error, 1), requeued: make(chan bool, 1)}
+    task.ID = id
+    task.Job = Job{Pkgname: "foo", Arch: "x86_64", MountType: "none"}
+    coord.tasks[id] = task
+    return task
+}
+
+// A response writer whose writes all fail, like one whose worker has gone
+type brokenWriter struct {
+    header http.Header
+}
+
+func (w *brokenWriter) Header() http.Header {
+    return w.header
+}
+
+func (w *brokenWriter) Write(data []byte) (int, error) {
+    return 0, errors.New("connection reset")
+}
+
+func (w *brokenWriter) WriteHeader(status int) {
+}
+
+func TestExpireLeases(t *testing.T) {
+    now := time.Now()
+    tests := []struct {
+        name string
+        worker string
+        lease time.Time
+        wantFailed bool
+    }{
+        {"pending", "", now.Add(-time.Hour), false},
+        {"lease current", "w-1", now.Add(time.Minute), false},
+        {"lease run out", "w-1", now.Add(-time.Second), true},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            coord := testCoordinator(cfg.Cfgs{})
+            task := testTask(coord, "foo-x86_64-1")
+            task.worker = test.worker
+            task.lease = test.lease
+
+            coord.expireLeases(now)
+            select {
+                case err := <-task.done:
+                    if !test.wantFailed {
+                        t.Errorf("task finished with %v", err)
+                    } else if err == nil || !str.Contains(err.Error(), "w-1 stopped responding") {
+                        t.Errorf("task failed with %v", err)
+                    }
+                default:
+                    if test.wantFailed {
+                        t.Errorf("task is still running")
+                    }
+            }
+        })
+    }
+}
+
+func TestHeartbeatRenewsLease(t *testing.T) {
+    coord := testCoordinator(cfg.Cfgs{})
+    task := testTask(coord, "foo-x86_64-1")
+    task.worker = "w-1"
+    task.lease = time.Now().Add(-time.Second)
+
+    w := httptest.NewRecorder()
+    coord.handler().ServeHTTP(w, httptest.NewRequest("POST", "/v1/job/foo-x86_64-1/heartbeat", nil))
+    if w.Code != http.StatusOK {
+        t.Fatalf("heartbeat gave %d", w.Code)
+    }
+    coord.expireLeases(time.Now())
+    select {
+        case err := <-task.done:
+            t.Errorf("task finished with %v after a heartbeat", err)
+        default:
+    }
+}
+
+func TestJobRequeuedWhenUnsent(t *testing.T) {
+    coord := testCoordinator(cfg.Cfgs{})
+    coord.workers["w-1"] = RemoteWorker{Name: "w"}
+    task := testTask(coord, "foo-x86_64-1")
+    go func() {
+        coord.pending <- task
+    }()
+
+    w := &brokenWriter{header: make(http.Header)}
+    coord.job(w, httptest.NewRequest("GET", "/v1/job?worker=w-1", nil))
+
+    select {
+        case <-task.requeued:
+            if task.worker != "" {
+                t.Errorf("requeued task is still given to %s", task.worker)
+            }
+        case <-time.After(time.Second):
+            t.Errorf("task was not requeued")
+    }
+}
+
+func TestBuildHandsRequeuedJobOutAgain(t *testing.T) {
+    coord := testCoordinator(cfg.Cfgs{})
+    coord.workers["w-1"] = RemoteWorker{Name: "w"}
+    built := make(chan error, 1)
+    go func() {
+        built <- coord.build(Job{Pkgname: "foo", Arch: "x86_64", MountType: "none"}, ioutil.Discard)
+    }()
+
+    // The first worker never gets it, the second does
+    coord.job(&brokenWriter{header: make(http.Header)}, httptest.NewRequest("GET", "/v1/job?worker=w-1", nil))
+    w := httptest.NewRecorder()
+    coord.job(w, httptest.NewRequest("GET", "/v1/job?worker=w-1", nil))
+    if w.Code != http.StatusOK || !str.Contains(w.Body.String(), "foo") {
+        t.Fatalf("second poll gave %d %q", w.Code, w.Body.String())
+    }
+
+    coord.mutex.Lock()
+    for _, task := range coord.tasks {
+        task.finish(nil)
+    }
+    coord.mutex.Unlock()
+    select {
+        case err := <-built:
+            if err != nil {
+                t.Errorf("build gave %v", err)
+            }
+        case <-time.After(5 * time.Second):
+            t.Errorf("the build never finished")
+    }
+}
+
+func TestBuildWaitingForWorkers(t *testing.T) {
+    tests := []struct {
+        name string
+        wait time.Duration
+        timeout time.Duration
+        wantErr string
+        wantTimeout bool
+    }{
+        {"no workers", 50 * time.Millisecond, 0, "No remote worker has been heard from", false},
+        {"package timeout", 0, 50 * time.Millisecond, "waiting for a remote worker", true},
+    }
+    remoteWaitCheck = 10 * time.Millisecond
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            coord := testCoordinator(cfg.Cfgs{RemoteWait: test.wait, TimeoutDefault: test.timeout})
+            built := make(chan error, 1)
+            go func() {
+                built <- coord.build(Job{Pkgname: "foo", Arch: "x86_64", MountType: "none"}, ioutil.Discard)
+            }()
+
+            select {
+                case err := <-built:
+                    if err == nil || !str.Contains(err.Error(), test.wantErr) {
+                        t.Errorf("build gave %v, want %q", err, test.wantErr)
+                    }
+                    if errors.Is(err, util.ErrTimeout) != test.wantTimeout {
+                        t.Errorf("timed out error is %v", err)
+                    }
+                case <-time.After(5 * time.Second):
+                    t.Fatal("the build waited forever")
+            }
+            if len(coord.tasks) != 0 {
+                t.Errorf("tasks left behind: %v", coord.tasks)
+            }
+        })
+    }
+}
+
+func TestBuildWaitsWhileWorkersAreAround(t *testing.T) {
+    remoteWaitCheck = 10 * time.Millisecond
+    coord := testCoordinator(cfg.Cfgs{RemoteWait: 100 * time.Millisecond})
+    built := make(chan error, 1)
+    go func() {
+        built <- coord.build(Job{Pkgname: "foo", Arch: "x86_64", MountType: "none"}, ioutil.Discard)
+    }()
+
+    // A busy worker keeps sending heartbeats for some other job
+    handler := coord.handler()
+    for i := 0; i < 10; i++ {
+        time.Sleep(30 * time.Millisecond)
+        handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/job/other/heartbeat", nil))
+    }
+    select {
+        case err := <-built:
+            t.Fatalf("build gave up with %v while a worker was around", err)
+        default:
+    }
+
+    // Once it stops, so do we
+    select {
+        case err := <-built:
+            if err == nil {
+                t.Errorf("build succeeded without a worker")
+            }
+        case <-time.After(5 * time.Second):
+            t.Fatal("the build waited forever")
+    }
+}
+
+// Put a binpkg in the repository of a void-packages
+func writeBinpkg(t *testing.T, vpkgPath string, fname string, contents string) {
+    dir := vpkgPath + "/hostdir/binpkgs"
+    err := os.MkdirAll(dir, 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = ioutil.WriteFile(dir + "/" + fname, []byte(contents), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+}
+
+// Put a stub xbps-rindex first in PATH, which records what it indexes
+func stubRindex(t *testing.T) string {
+    dir := t.TempDir()
+    err := ioutil.WriteFile(dir + "/xbps-rindex", []byte("#!/bin/sh\necho \"$@\" >> '" + dir +
+        "/indexed'\n"), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    path := os.Getenv("PATH")
+    os.Setenv("PATH", dir + ":" + path)
+    t.Cleanup(func() {
+        os.Setenv("PATH", path)
+    })
+    return dir + "/indexed"
+}
+
+func TestRemoteRoundTrip(t *testing.T) {
+    tests := []struct {
+        name string
+        buildErr error
+        // Empty if the build succeeds
+        wantErr string
+        wantTimeout bool
+    }{
+        {"success", nil, "", false},
+        {"failure", errors.New("xbps-src failed"), "xbps-src failed", false},
+        {"timeout", fmt.Errorf("build %w", util.ErrTimeout), "timed out", true},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            indexed := stubRindex(t)
+            coordCfg := cfg.Cfgs{VpkgPath: t.TempDir(), HostArch: "x86_64", RemoteToken: "secret"}
+            writeBinpkg(t, coordCfg.VpkgPath, "bar-1.0_1.x86_64.xbps", "bar")
+            coord := testCoordinator(coordCfg)
+            server := httptest.NewServer(coord.handler())
+            defer server.Close()
+
+            w := worker{cfg: cfg.Cfgs{VpkgPath: t.TempDir(), HostArch: "x86_64", RemoteToken: "secret"},
+                coordinator: server.URL, name: "test", client: server.Client()}
+            err := w.register()
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            // The coordinator hands the job to the first worker asking
+            var logs bytes.Buffer
+            built := make(chan error, 1)
+            go func() {
+                built <- coord.build(Job{Pkgname: "foo", Arch: "x86_64", MountType: "none"}, &logs)
+            }()
+            var job RemoteJob
+            _, err = w.request("GET", "/v1/job?worker=" + w.id, nil, &job)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if job.Job.Pkgname != "foo" {
+                t.Fatalf("worker was given %s", job.Job.Pkgname)
+            }
+
+            // The worker fetches what it is missing, builds, and uploads
+            // what it built
+            err = w.syncBinpkgs()
+            if err != nil {
+                t.Fatal(err)
+            }
+            got, err := ioutil.ReadFile(w.cfg.VpkgPath + "/hostdir/binpkgs/bar-1.0_1.x86_64.xbps")
+            if err != nil || string(got[:]) != "bar" {
+                t.Errorf("fetched bar is %q, %v", got, err)
+            }
+            before, err := w.binpkgTimes()
+            if err != nil {
+                t.Fatal(err)
+            }
+            writeBinpkg(t, w.cfg.VpkgPath, "foo-1.0_1.x86_64.xbps", "foo")
+            _, err = w.request("POST", "/v1/job/" + job.ID + "/logs", str.NewReader("building foo\n"), nil)
+            if err != nil {
+                t.Fatal(err)
+            }
+            err = w.uploadBinpkgs(job, before)
+            if err != nil {
+                t.Fatal(err)
+            }
+            err = w.result(job, test.buildErr)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            select {
+                case err = <-built:
+                case <-time.After(5 * time.Second):
+                    t.Fatal("the build never finished")
+            }
+            if test.wantErr == "" && err != nil {
+                t.Errorf("build gave %v", err)
+            } else if test.wantErr != "" && (err == nil || !str.Contains(err.Error(), test.wantErr)) {
+                t.Errorf("build gave %v, want %q", err, test.wantErr)
+            }
+            if errors.Is(err, util.ErrTimeout) != test.wantTimeout {
+                t.Errorf("timed out error is %v", err)
+            }
+            if logs.String() != "building foo\n" {
+                t.Errorf("logs are %q", logs.String())
+            }
+            got, err = ioutil.ReadFile(coordCfg.VpkgPath + "/hostdir/binpkgs/foo-1.0_1.x86_64.xbps")
+            if err != nil || string(got[:]) != "foo" {
+                t.Errorf("uploaded foo is %q, %v", got, err)
+            }
+
+            // Both ends indexed what they were given
+            calls, err := ioutil.ReadFile(indexed)
+            if err != nil {
+                t.Fatal(err)
+            }
+            for _, want := range []string{w.cfg.VpkgPath + "/hostdir/binpkgs/bar-1.0_1.x86_64.xbps",
+                coordCfg.VpkgPath + "/hostdir/binpkgs/foo-1.0_1.x86_64.xbps"} {
+                if !str.Contains(string(calls[:]), "-a " + want) {
+                    t.Errorf("%s was not indexed in %q", want, calls)
+                }
+            }
+        })
+    }
+}
+
+func TestRemoteRejected(t *testing.T) {
+    tests := []struct {
+        name string
+        token string
+        hostArch string
+    }{
+        {"no token", "", "x86_64"},
+        {"wrong token", "guess", "x86_64"},
+        {"wrong host arch", "secret", "aarch64"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            coord := testCoordinator(cfg.Cfgs{VpkgPath: t.TempDir(), HostArch: "x86_64", RemoteToken: "secret"})
+            server := httptest.NewServer(coord.handler())
+            defer server.Close()
+
+            w := worker{cfg: cfg.Cfgs{HostArch: test.hostArch, RemoteToken: test.token},
+                coordinator: server.URL, name: "test", client: server.Client()}
+            err := w.register()
+            if err == nil {
+                t.Errorf("registered as %s", w.id)
+            }
+            if len(coord.workers) != 0 {
+                t.Errorf("coordinator knows %v", coord.workers)
+            }
+        })
+    }
+}
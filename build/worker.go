@@ -0,0 +1,333 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package build
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "github.com/fosslinux/vxb/util"
+    "github.com/fosslinux/vxb/vpkgs"
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "os"
+    "path/filepath"
+    str "strings"
+    "time"
+)
+
+// How long to wait before trying the coordinator again after an error
+var workerRetryDelay = 5 * time.Second
+
+// A worker building packages for a coordinator
+type worker struct {
+    cfg cfg.Cfgs
+    coordinator string
+    name string
+    client *http.Client
+    id string
+}
+
+// Make a request to the coordinator
+// If out is not nil, the response is decoded into it. The status code is
+// returned so callers can handle "nothing to do" and "who are you".
+func (w *worker) request(method string, path string, body io.Reader, out interface{}) (int, error) {
+    req, err := http.NewRequest(method, str.TrimSuffix(w.coordinator, "/") + path, body)
+    if err != nil {
+        return 0, fmt.Errorf("Error %w creating request to %s", err, path)
+    }
+    if w.cfg.RemoteToken != "" {
+        req.Header.Set("X-Vxb-Token", w.cfg.RemoteToken)
+    }
+    resp, err := w.client.Do(req)
+    if err != nil {
+        return 0, fmt.Errorf("Error %w requesting %s %s", err, method, path)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        msg, _ := ioutil.ReadAll(resp.Body)
+        return resp.StatusCode, fmt.Errorf("Coordinator returned %s for %s %s: %s", resp.Status,
+            method, path, str.TrimSpace(string(msg[:])))
+    }
+    if out != nil {
+        err = json.NewDecoder(resp.Body).Decode(out)
+        if err != nil {
+            return resp.StatusCode, fmt.Errorf("Error %w decoding response from %s", err, path)
+        }
+    }
+    return resp.StatusCode, nil
+}
+
+// Register with the coordinator
+func (w *worker) register() error {
+    data, err := json.Marshal(RemoteWorker{Name: w.name, HostArch: w.cfg.HostArch})
+    if err != nil {
+        return fmt.Errorf("Error %w encoding registration", err)
+    }
+    var reg RemoteRegistration
+    _, err = w.request("POST", "/v1/register", bytes.NewReader(data), &reg)
+    if err != nil {
+        return err
+    }
+    w.id = reg.ID
+    fmt.Printf("Registered with %s as %s.\n", w.coordinator, w.id)
+    return nil
+}
+
+// Fetch the binpkgs the coordinator has that we don't
+// Everything a package depends on has been built by the time it is handed
+// out, but not necessarily by us.
+func (w *worker) syncBinpkgs() error {
+    var theirs map[string]int64
+    _, err := w.request("GET", "/v1/binpkgs", nil, &theirs)
+    if err != nil {
+        return err
+    }
+    ours, err := vpkgs.ListBinpkgs(w.cfg)
+    if err != nil {
+        return err
+    }
+
+    root := w.cfg.VpkgPath + "/hostdir/binpkgs"
+    fetched := make(map[string][]string)
+    for rel, size := range theirs {
+        if ours[rel] == size {
+            continue
+        }
+        path, err := binpkgPath(root, rel)
+        if err != nil {
+            return err
+        }
+        err = os.MkdirAll(filepath.Dir(path), 0755)
+        if err != nil {
+            return fmt.Errorf("Unable to create %s with %w", filepath.Dir(path), err)
+        }
+
+        req, err := http.NewRequest("GET", str.TrimSuffix(w.coordinator, "/") + "/v1/binpkgs/" +
+            (&url.URL{Path: filepath.ToSlash(rel)}).EscapedPath(), nil)
+        if err != nil {
+            return fmt.Errorf("Error %w creating request for %s", err, rel)
+        }
+        if w.cfg.RemoteToken != "" {
+            req.Header.Set("X-Vxb-Token", w.cfg.RemoteToken)
+        }
+        resp, err := w.client.Do(req)
+        if err != nil {
+            return fmt.Errorf("Error %w fetching %s", err, rel)
+        }
+        if resp.StatusCode != http.StatusOK {
+            resp.Body.Close()
+            return fmt.Errorf("Coordinator returned %s for %s", resp.Status, rel)
+        }
+        out, err := os.Create(path)
+        if err != nil {
+            resp.Body.Close()
+            return fmt.Errorf("Error %w creating %s", err, path)
+        }
+        _, err = io.Copy(out, resp.Body)
+        resp.Body.Close()
+        out.Close()
+        if err != nil {
+            os.Remove(path)
+            return fmt.Errorf("Error %w fetching %s", err, rel)
+        }
+
+        arch := vpkgs.BinpkgArch(path, w.cfg.HostArch)
+        fetched[arch] = append(fetched[arch], path)
+    }
+
+    for arch, binpkgs := range fetched {
+        err = vpkgs.IndexBinpkgs(binpkgs, arch)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Modification times of every local binpkg
+func (w *worker) binpkgTimes() (map[string]time.Time, error) {
+    times := make(map[string]time.Time)
+    root := w.cfg.VpkgPath + "/hostdir/binpkgs"
+    binpkgs, err := vpkgs.ListBinpkgs(w.cfg)
+    if err != nil {
+        return times, err
+    }
+    for rel := range binpkgs {
+        info, err := os.Stat(root + "/" + rel)
+        if err != nil {
+            return times, fmt.Errorf("Error %w checking %s", err, rel)
+        }
+        times[rel] = info.ModTime()
+    }
+    return times, nil
+}
+
+// Upload the binpkgs written since before was taken to the coordinator
+func (w *worker) uploadBinpkgs(job RemoteJob, before map[string]time.Time) error {
+    root := w.cfg.VpkgPath + "/hostdir/binpkgs"
+    after, err := w.binpkgTimes()
+    if err != nil {
+        return err
+    }
+
+    for rel, modTime := range after {
+        if prev, exists := before[rel]; exists && prev.Equal(modTime) {
+            continue
+        }
+
+        f, err := os.Open(root + "/" + rel)
+        if err != nil {
+            return fmt.Errorf("Error %w opening %s", err, rel)
+        }
+        fmt.Printf("Uploading %s...\n", rel)
+        _, err = w.request("POST", "/v1/job/" + url.PathEscape(job.ID) + "/binpkg?path=" +
+            url.QueryEscape(filepath.ToSlash(rel)), f, nil)
+        f.Close()
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Build a job, streaming its logs to the coordinator
+func (w *worker) build(job RemoteJob) error {
+    err := w.syncBinpkgs()
+    if err != nil {
+        return err
+    }
+    err = vpkgs.RefreshPristine(os.Stdout, w.cfg)
+    if err != nil {
+        return err
+    }
+
+    // Build exactly as the coordinator would have
+    jobCfg := w.cfg
+    jobCfg.MountPkgs = map[string]string{job.Job.Pkgname: job.Job.MountType}
+    jobCfg.MountSize = map[string]string{job.Job.MountType: job.MountSize}
+    jobCfg.SubRepos = make(map[string]string)
+    if job.SubRepo != "" {
+        jobCfg.SubRepos[job.Job.Arch] = job.SubRepo
+    }
+    jobCfg.TimeoutDefault = job.Timeout
+    jobCfg.TimeoutPkgs = nil
+    // The coordinator retries the job itself
+    jobCfg.RetryDefault = cfg.RetryPolicy{Attempts: 1}
+    jobCfg.RetryPkgs = nil
+
+    // Anything changed by the build is uploaded afterwards
+    before, err := w.binpkgTimes()
+    if err != nil {
+        return err
+    }
+
+    // Logs go back to the coordinator as the build runs
+    logsR, logsW := io.Pipe()
+    logsDone := make(chan error, 1)
+    go func() {
+        _, err := w.request("POST", "/v1/job/" + url.PathEscape(job.ID) + "/logs", logsR, nil)
+        // Don't block the build if the coordinator stopped listening
+        logsR.CloseWithError(err)
+        logsDone <- err
+    }()
+
+    // The coordinator keeps the log file
+    ident := job.Job.Pkgname + "@" + job.Job.Arch
+    log, err := NewLog(ident, "", io.MultiWriter(os.Stdout, logsW))
+    if err == nil {
+        err = Build(&Local{cfg: jobCfg}, ident, job.Job.Force, log, jobCfg)
+    }
+    logsW.Close()
+    logsErr := <-logsDone
+    if err != nil {
+        return err
+    }
+    if logsErr != nil {
+        return logsErr
+    }
+
+    return w.uploadBinpkgs(job, before)
+}
+
+// Tell the coordinator we are still building a job until stop is closed
+func (w *worker) heartbeat(job RemoteJob, stop chan struct{}) {
+    ticker := time.NewTicker(remoteHeartbeatInterval)
+    defer ticker.Stop()
+    for {
+        select {
+            case <-ticker.C:
+                _, err := w.request("POST", "/v1/job/" + url.PathEscape(job.ID) + "/heartbeat", nil, nil)
+                if err != nil {
+                    fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+                }
+            case <-stop:
+                return
+        }
+    }
+}
+
+// Tell the coordinator how a job went
+func (w *worker) result(job RemoteJob, buildErr error) error {
+    result := RemoteResult{}
+    if buildErr != nil {
+        result.Error = buildErr.Error()
+        result.TimedOut = errors.Is(buildErr, util.ErrTimeout)
+    }
+    data, err := json.Marshal(result)
+    if err != nil {
+        return fmt.Errorf("Error %w encoding result", err)
+    }
+    _, err = w.request("POST", "/v1/job/" + url.PathEscape(job.ID) + "/result", bytes.NewReader(data), nil)
+    return err
+}
+
+// Build packages handed out by a coordinator, forever
+func RunWorker(coordinator string, name string, cfg cfg.Cfgs) error {
+    w := worker{cfg: cfg, coordinator: coordinator, name: name, client: &http.Client{}}
+
+    for {
+        // (Re-)register if the coordinator doesn't know us
+        if w.id == "" {
+            err := w.register()
+            if err != nil {
+                fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+                time.Sleep(workerRetryDelay)
+                continue
+            }
+        }
+
+        var job RemoteJob
+        status, err := w.request("GET", "/v1/job?worker=" + url.QueryEscape(w.id), nil, &job)
+        if status == http.StatusNoContent {
+            continue
+        } else if status == http.StatusNotFound {
+            w.id = ""
+            continue
+        } else if err != nil {
+            fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+            time.Sleep(workerRetryDelay)
+            continue
+        }
+
+        fmt.Printf("Building %s@%s...\n", job.Job.Pkgname, job.Job.Arch)
+        stop := make(chan struct{})
+        go w.heartbeat(job, stop)
+        buildErr := w.build(job)
+        close(stop)
+        if buildErr != nil {
+            fmt.Fprintf(os.Stderr, "ERROR: %s\n", buildErr)
+        }
+        err = w.result(job, buildErr)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+        }
+    }
+}
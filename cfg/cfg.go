@@ -7,9 +7,12 @@ import (
     "github.com/ryanuber/go-glob"
     "github.com/go-ini/ini"
     "golang.org/x/sys/unix"
+    "net"
     str "strings"
     "os"
     "fmt"
+    "path/filepath"
+    "time"
 )
 
 // Configuration struct
@@ -18,8 +21,10 @@ type Cfgs struct {
     VpkgPath string
     // "subrepo" i.e. -r argument
     SubRepos map[string]string
-    // Architecture to build for
+    // Architecture(s) to build for, as given (a list of names or globs)
     Arch string
+    // Architectures to build for, with globs expanded
+    Arches []string
     // Packages to build (specified on cmdline)
     SPkgNames string
     // Host architecture
@@ -38,6 +43,77 @@ type Cfgs struct {
     MountPkgs map[string]string
     // Sizes of different types of mounts
     MountSize map[string]string
+    // Number of packages to build at once
+    Jobs int
+    // Name of the masterdir (within VpkgPath) to use
+    Masterdir string
+    // Keep building other packages after a failure
+    KeepGoing bool
+    // Build every package in srcpkgs/
+    Universe bool
+    // Path to the file the graph and build status is saved to
+    StatePath string
+    // Resume from the state file instead of generating a new graph
+    Resume bool
+    // Only print what would be built
+    Plan bool
+    // Print the plan as JSON
+    JSON bool
+    // Dependencies of packages to leave out of the graph
+    IgnoreEdges map[string][]string
+    // Cache dbulk-dump results
+    Cache bool
+    // Directory to cache dbulk-dump results in
+    CachePath string
+    // Build packages with restricted=yes
+    AllowRestricted bool
+    // Also rebuild everything depending on the packages
+    Revdeps bool
+    // How long a package may take to build (0 means forever)
+    TimeoutDefault time.Duration
+    // Per-package overrides of TimeoutDefault
+    TimeoutPkgs map[string]time.Duration
+    // How to retry failed builds
+    RetryDefault RetryPolicy
+    // Per-package overrides of RetryDefault
+    RetryPkgs map[string]RetryPolicy
+    // Directory to write per-package build logs to (empty for none)
+    LogDir string
+    // Number of runs to keep the logs of (0 keeps all)
+    LogKeep int
+    // Path of the build history database (empty for none)
+    HistoryPath string
+    // How masterdirs are cloned from a pristine one (none, reflink or overlay)
+    Snapshot string
+    // How packages are built (see build.Executors)
+    Executor string
+    // Container runtime CLI (docker, podman, etc) for the container executor
+    ContainerRuntime string
+    // Image to build in for the container executor
+    ContainerImage string
+    // Extra arguments given to the container runtime when running
+    ContainerArgs []string
+    // Address of the Nomad HTTP API for the nomad executor
+    NomadAddress string
+    // ACL token for the Nomad HTTP API, if any
+    NomadToken string
+    // Datacenter to run Nomad jobs in
+    NomadDatacenter string
+    // CPU (MHz) and memory (MB) to request for each Nomad job
+    NomadCPU int
+    NomadMemory int
+    // Path to void-packages on the Nomad clients
+    NomadVpkgPath string
+    // Address the coordinator listens on for remote workers
+    RemoteListen string
+    // Shared secret between the coordinator and remote workers, if any
+    RemoteToken string
+    // How long to wait for a job to be taken without hearing from any remote
+    // worker (0 means forever)
+    RemoteWait time.Duration
+    // Check for packages whose shlibs are no longer provided (report or
+    // rebuild)
+    Shlibs string
 
     // Other structures
     // All of the git configuration
@@ -75,6 +151,16 @@ type Repo struct {
     ChangeFail string
 }
 
+// How to retry a failed build
+type RetryPolicy struct {
+    // Total number of attempts (at least 1)
+    Attempts int
+    // Time to wait before the first retry, doubled for each one after
+    Backoff time.Duration
+    // Mount type to use for retries, empty to keep the same one
+    FallbackMount string
+}
+
 // Basic list of valid architectures
 var validArchs = []string{"aarch64", "armv5tel", "armv6l", "armv7l", "i686",
     "mips-musl", "mipsel-musl", "mipselhf-musl", "mipshf-musl", "ppc",
@@ -89,6 +175,25 @@ func (cfg *Cfgs) InitOpt() {
         }
     }
 
+    // Default masterdir, only changed for parallel builds
+    cfg.Masterdir = "masterdir"
+    // Caching is on unless disabled in the config file
+    cfg.Cache = true
+    // Executor defaults, for when there is no config file
+    cfg.ContainerRuntime = "docker"
+    cfg.NomadAddress = "http://127.0.0.1:4646"
+    cfg.NomadDatacenter = "dc1"
+    cfg.NomadCPU = 1000
+    cfg.NomadMemory = 2048
+    cfg.RemoteListen = "127.0.0.1:8765"
+    cfg.RemoteWait = 10 * time.Minute
+    // Logs of the last 10 runs are kept in logs/
+    cfg.LogDir = "logs"
+    cfg.LogKeep = 10
+    cfg.HistoryPath = "vxb-history.db"
+    // Every masterdir is bootstrapped from scratch
+    cfg.Snapshot = "none"
+
     cfg.Opt = getoptions.New()
     cfg.Opt.SetMode(getoptions.Bundling)
     // Create the git structure
@@ -104,7 +209,7 @@ func (cfg *Cfgs) AddOpts() {
     opt.StringVar(&cfg.VpkgPath, "vpkg", "", opt.Alias("v"),
         opt.Description("Path to void-packages checkout."))
     opt.StringVar(&cfg.Arch, "arch", "", opt.Required(), opt.Alias("a"),
-        opt.Description("The architecture to build for."))
+        opt.Description("The architecture(s) to build for (a list, may be globs)."))
     opt.StringVar(&cfg.SPkgNames, "pkgname", "", opt.Alias("p"),
         opt.Description("The package(s) to build."))
     opt.StringVarOptional(&cfg.Git.Commits, "git", "", opt.Alias("g"),
@@ -115,6 +220,26 @@ func (cfg *Cfgs) AddOpts() {
         opt.Description("Configuration file path."))
     opt.BoolVar(&cfg.Mods, "mods", false, opt.Alias("d"),
         opt.Description("Modifications are made from upstream void-packages."))
+    opt.IntVar(&cfg.Jobs, "jobs", 1, opt.Alias("j"),
+        opt.Description("Number of packages to build at once."))
+    opt.BoolVar(&cfg.KeepGoing, "keep-going", false, opt.Alias("k"),
+        opt.Description("Keep building packages unaffected by a failure."))
+    opt.BoolVar(&cfg.Universe, "universe", false, opt.Alias("u"),
+        opt.Description("Build every package in void-packages."))
+    opt.StringVar(&cfg.StatePath, "state", "vxb-state.json", opt.Alias("s"),
+        opt.Description("Path to save the graph and build status to."))
+    opt.BoolVar(&cfg.Resume, "resume", false, opt.Alias("r"),
+        opt.Description("Resume an interrupted run from the state file."))
+    opt.BoolVar(&cfg.Plan, "plan", false, opt.Alias("n"),
+        opt.Description("Print the build order without building anything."))
+    opt.BoolVar(&cfg.JSON, "json", false,
+        opt.Description("Print the build order as JSON."))
+    opt.BoolVar(&cfg.Revdeps, "revdeps", false, opt.Alias("R"),
+        opt.Description("Also rebuild every package depending on the packages."))
+    opt.StringVar(&cfg.Executor, "executor", "local", opt.Alias("e"),
+        opt.Description("How packages are built."))
+    opt.StringVarOptional(&cfg.Shlibs, "shlibs", "report", opt.Alias("S"),
+        opt.Description("Find packages requiring shlibs nothing provides (report or rebuild)."))
 }
 
 // Act on options
@@ -184,7 +309,7 @@ func (cfg *Cfgs) parseMountDefault() {
                 cfg.MountDefault != "tmpfs" &&
                 cfg.MountDefault != "zram" &&
                 cfg.MountDefault != "zram-zstd" {
-        fmt.Fprintf(os.Stderr, "ERROR: %s is not a valid default mount type (valid: none, tmpfs, zram, zram-zstd.\n");
+        fmt.Fprintf(os.Stderr, "ERROR: %s is not a valid default mount type (valid: none, tmpfs, zram, zram-zstd.\n", cfg.MountDefault)
         os.Exit(1)
     }
 }
@@ -239,6 +364,20 @@ func (cfg *Cfgs) parseSubrepo() {
             os.Exit(1)
         }
     }
+
+    // -32bit packages for x86_64 are generated by i686 builds into the
+    // multilib/ subdirectory of the i686 repository, so i686 must share the
+    // x86_64 subrepo for them to be found.
+    x86Repo, x86Exists := cfg.SubRepos["x86_64"]
+    i686Repo, i686Exists := cfg.SubRepos["i686"]
+    if x86Exists && !i686Exists {
+        fmt.Fprintf(os.Stderr, "WARN: Using the x86_64 subrepo %s for i686 too, so -32bit packages are in the x86_64 multilib repository.\n", x86Repo)
+        cfg.SubRepos["i686"] = x86Repo
+    } else if x86Exists && i686Repo != x86Repo {
+        fmt.Fprintf(os.Stderr, "WARN: i686 and x86_64 use different subrepos, so -32bit packages will not be in the x86_64 multilib repository.\n")
+    } else if i686Exists && !x86Exists {
+        fmt.Fprintf(os.Stderr, "WARN: i686 has a subrepo but x86_64 does not, so -32bit packages will not be in the x86_64 multilib repository.\n")
+    }
 }
 
 // Parse the config file
@@ -257,8 +396,6 @@ func (cfg *Cfgs) ParseCfg() error {
 
     cfg.parseSubrepo()
 
-    cfg.validateArch()
-
     // Modifications
     if !cfg.Opt.Called("mods") {
         var err error
@@ -275,9 +412,258 @@ func (cfg *Cfgs) ParseCfg() error {
     cfg.parseMountSize()
     cfg.validateMountSizes()
 
+    cfg.parseJobs()
+    cfg.parseKeepGoing()
+    cfg.parseLog()
+    cfg.parseHistory()
+    cfg.parseTimeouts()
+    cfg.parseRetries()
+    cfg.parseExecutor()
+    cfg.parseSnapshot()
+    cfg.parseContainer()
+    cfg.parseNomad()
+    cfg.parseRemote()
+    cfg.parseAllowRestricted()
+    cfg.parseIgnoreEdges()
+    cfg.parseCache()
+
+    // State file
+    if !cfg.Opt.Called("state") {
+        statePath := cfg.cfgf.Section("build").Key("state").String()
+        if statePath != "" {
+            cfg.StatePath = statePath
+        }
+    }
+
     return nil
 }
 
+// Parse the number of parallel builds
+func (cfg *Cfgs) parseJobs() {
+    if !cfg.Opt.Called("jobs") {
+        jobs := cfg.cfgf.Section("build").Key("jobs").MustInt(1)
+        cfg.Jobs = jobs
+    }
+    cfg.ValidJobs()
+}
+
+// Parse whether to keep going after a failure
+func (cfg *Cfgs) parseKeepGoing() {
+    if !cfg.Opt.Called("keep-going") {
+        var err error
+        cfg.KeepGoing, err = cfg.cfgf.Section("build").Key("keep_going").Bool()
+        if err != nil {
+            cfg.KeepGoing = false
+        }
+    }
+}
+
+// Parse whether restricted packages may be built
+func (cfg *Cfgs) parseAllowRestricted() {
+    cfg.AllowRestricted = cfg.cfgf.Section("build").Key("allow_restricted").MustBool(false)
+}
+
+// Parse the log section
+func (cfg *Cfgs) parseLog() {
+    section := cfg.cfgf.Section("log")
+    if section.HasKey("dir") {
+        cfg.LogDir = section.Key("dir").String()
+    }
+    if section.HasKey("keep") {
+        keep, err := section.Key("keep").Int()
+        if err != nil || keep < 0 {
+            fmt.Fprintf(os.Stderr, "ERROR: keep in [log] must be a number of runs (0 keeps all).\n")
+            os.Exit(1)
+        }
+        cfg.LogKeep = keep
+    }
+}
+
+// Parse the history section
+// vxb history can be pointed at another database with --db. Otherwise, a
+// relative path (including the default) is relative to the config file, so
+// vxb history finds the same database wherever it is run from.
+func (cfg *Cfgs) parseHistory() {
+    if cfg.Opt.Called("db") {
+        return
+    }
+    if cfg.cfgf.Section("history").HasKey("path") {
+        cfg.HistoryPath = cfg.cfgf.Section("history").Key("path").String()
+    }
+    if cfg.HistoryPath != "" && !filepath.IsAbs(cfg.HistoryPath) {
+        cfg.HistoryPath = filepath.Join(filepath.Dir(cfg.ConfPath), cfg.HistoryPath)
+    }
+}
+
+// Parse only the history section of the config file, for vxb history
+func (cfg *Cfgs) ParseHistoryCfg() {
+    cfg.parseHistory()
+}
+
+// Parse how masterdirs are snapshotted
+func (cfg *Cfgs) parseSnapshot() {
+    if cfg.cfgf.Section("build").HasKey("snapshot") {
+        cfg.Snapshot = cfg.cfgf.Section("build").Key("snapshot").String()
+    }
+}
+
+// Parse the build.timeout section
+func (cfg *Cfgs) parseTimeouts() {
+    cfg.TimeoutPkgs = make(map[string]time.Duration)
+    for key, value := range cfg.cfgf.Section("build.timeout").KeysHash() {
+        timeout, err := time.ParseDuration(value)
+        if err != nil || timeout < 0 {
+            fmt.Fprintf(os.Stderr, "ERROR: %s is not a valid timeout for %s (e.g. 90m or 2h).\n", value, key)
+            os.Exit(1)
+        }
+        if key == "default" {
+            cfg.TimeoutDefault = timeout
+        } else {
+            cfg.TimeoutPkgs[key] = timeout
+        }
+    }
+}
+
+// How long a package may take to build (0 means forever)
+func (cfg *Cfgs) Timeout(pkgName string) time.Duration {
+    if timeout, exists := cfg.TimeoutPkgs[pkgName]; exists {
+        return timeout
+    }
+    return cfg.TimeoutDefault
+}
+
+// Parse a retry policy section, with anything not given taken from base
+func (cfg *Cfgs) parseRetryPolicy(section *ini.Section, base RetryPolicy) RetryPolicy {
+    policy := base
+    name := section.Name()
+
+    if section.HasKey("attempts") {
+        attempts, err := section.Key("attempts").Int()
+        if err != nil || attempts < 1 {
+            fmt.Fprintf(os.Stderr, "ERROR: attempts in [%s] must be at least 1.\n", name)
+            os.Exit(1)
+        }
+        policy.Attempts = attempts
+    }
+    if section.HasKey("backoff") {
+        backoff, err := time.ParseDuration(section.Key("backoff").String())
+        if err != nil || backoff < 0 {
+            fmt.Fprintf(os.Stderr, "ERROR: %s is not a valid backoff in [%s] (e.g. 30s or 5m).\n", section.Key("backoff").String(), name)
+            os.Exit(1)
+        }
+        policy.Backoff = backoff
+    }
+    if section.HasKey("fallback_mount") {
+        policy.FallbackMount = section.Key("fallback_mount").String()
+        if policy.FallbackMount != "none" && cfg.MountSize[policy.FallbackMount] == "" {
+            fmt.Fprintf(os.Stderr, "ERROR: %s is the fallback mount type in [%s] but is not a mount type with a size set.\n", policy.FallbackMount, name)
+            os.Exit(1)
+        }
+    }
+
+    return policy
+}
+
+// Parse the build.retry section and its per-package children
+// (build.retry.<pkgname>)
+func (cfg *Cfgs) parseRetries() {
+    retry := cfg.cfgf.Section("build.retry")
+    cfg.RetryDefault = cfg.parseRetryPolicy(retry, RetryPolicy{Attempts: 1})
+    cfg.RetryPkgs = make(map[string]RetryPolicy)
+    for _, section := range retry.ChildSections() {
+        pkgName := str.TrimPrefix(section.Name(), "build.retry.")
+        cfg.RetryPkgs[pkgName] = cfg.parseRetryPolicy(section, cfg.RetryDefault)
+    }
+}
+
+// How to retry a failed build of a package
+func (cfg *Cfgs) Retry(pkgName string) RetryPolicy {
+    policy, exists := cfg.RetryPkgs[pkgName]
+    if !exists {
+        policy = cfg.RetryDefault
+    }
+    if policy.Attempts < 1 {
+        policy.Attempts = 1
+    }
+    return policy
+}
+
+// Parse the executor to build packages with
+func (cfg *Cfgs) parseExecutor() {
+    if !cfg.Opt.Called("executor") {
+        executor := cfg.cfgf.Section("build").Key("executor").String()
+        if executor != "" {
+            cfg.Executor = executor
+        }
+    }
+}
+
+// Parse the executor.container section
+func (cfg *Cfgs) parseContainer() {
+    section := cfg.cfgf.Section("executor.container")
+    cfg.ContainerRuntime = section.Key("runtime").String()
+    if cfg.ContainerRuntime == "" {
+        cfg.ContainerRuntime = "docker"
+    }
+    cfg.ContainerImage = section.Key("image").String()
+    cfg.ContainerArgs = str.Fields(section.Key("args").String())
+}
+
+// Parse the executor.nomad section
+func (cfg *Cfgs) parseNomad() {
+    section := cfg.cfgf.Section("executor.nomad")
+    cfg.NomadAddress = section.Key("address").MustString("http://127.0.0.1:4646")
+    cfg.NomadToken = section.Key("token").String()
+    cfg.NomadDatacenter = section.Key("datacenter").MustString("dc1")
+    cfg.NomadCPU = section.Key("cpu").MustInt(1000)
+    cfg.NomadMemory = section.Key("memory").MustInt(2048)
+    // By default the clients have void-packages in the same place we do
+    cfg.NomadVpkgPath = section.Key("vpkg_path").MustString(cfg.VpkgPath)
+}
+
+// Parse the executor.remote section
+func (cfg *Cfgs) parseRemote() {
+    section := cfg.cfgf.Section("executor.remote")
+    cfg.RemoteListen = section.Key("listen").MustString("127.0.0.1:8765")
+    cfg.RemoteToken = section.Key("token").String()
+    if section.HasKey("wait") {
+        wait, err := time.ParseDuration(section.Key("wait").String())
+        if err != nil || wait < 0 {
+            fmt.Fprintf(os.Stderr, "ERROR: %s is not a valid wait for remote workers (e.g. 10m, 0 waits forever).\n",
+                section.Key("wait").String())
+            os.Exit(1)
+        }
+        cfg.RemoteWait = wait
+    }
+}
+
+// Parse the cache section
+func (cfg *Cfgs) parseCache() {
+    enable, err := cfg.cfgf.Section("cache").Key("enable").Bool()
+    if err == nil {
+        cfg.Cache = enable
+    }
+    cfg.CachePath = cfg.cfgf.Section("cache").Key("path").String()
+}
+
+// Parse the graph.ignore_edges section
+func (cfg *Cfgs) parseIgnoreEdges() {
+    cfg.IgnoreEdges = make(map[string][]string)
+    for pkgName, depNames := range cfg.cfgf.Section("graph.ignore_edges").KeysHash() {
+        cfg.IgnoreEdges[pkgName] = str.Fields(depNames)
+    }
+}
+
+// Check if the dependency of a package on another should be ignored
+func (cfg *Cfgs) IgnoredEdge(pkgName string, depName string) bool {
+    for _, ignored := range cfg.IgnoreEdges[pkgName] {
+        if ignored == depName {
+            return true
+        }
+    }
+    return false
+}
+
 // Parse hostarch
 func (cfg *Cfgs) parseHostArch() error {
     // Host architecture
@@ -418,14 +804,27 @@ func (cfg *Cfgs) ParseGitCfg() {
 
 // Evaluate automatic -musl extension
 func (cfg *Cfgs) EvalAutoMuslExt() {
-    // If the architecture is -musl and the host was not manually set, then
+    // If every architecture is -musl and the host was not manually set, then
     // the host should also be -musl.
     hostManual := !cfg.Opt.Called("hostarch") || cfg.SysInfo != nil
-    if str.HasSuffix(cfg.Arch, "-musl") && hostManual {
+    allMusl := true
+    for _, arch := range cfg.Arches {
+        if !str.HasSuffix(arch, "-musl") {
+            allMusl = false
+        }
+    }
+    if allMusl && hostManual {
         cfg.HostArch += "-musl"
     }
 }
 
+// Evaluate the default cache path, which lives in hostdir
+func (cfg *Cfgs) EvalCachePath() {
+    if cfg.CachePath == "" {
+        cfg.CachePath = cfg.VpkgPath + "/hostdir/vxb-cache"
+    }
+}
+
 // Validate that a VpkgPath was given
 func (cfg *Cfgs) ValidVpkgPath() {
     if cfg.VpkgPath == "" {
@@ -434,6 +833,92 @@ func (cfg *Cfgs) ValidVpkgPath() {
     }
 }
 
+// Validate that a universe build is not mixed with other package selection
+func (cfg *Cfgs) ValidUniverse() {
+    if cfg.Universe && (cfg.Opt.Called("git") || cfg.Opt.Called("pkgname") || cfg.Resume) {
+        fmt.Fprintf(os.Stderr, "ERROR: A universe build cannot be combined with packages, git or resuming.\n")
+        os.Exit(1)
+    }
+}
+
+// Validate that a reverse dependency build has something to work from
+func (cfg *Cfgs) ValidRevdeps() {
+    if cfg.Revdeps && (cfg.Universe || cfg.Resume) {
+        fmt.Fprintf(os.Stderr, "ERROR: Reverse dependencies cannot be combined with universe or resuming.\n")
+        os.Exit(1)
+    }
+}
+
+// Validate the shlib check mode
+func (cfg *Cfgs) ValidShlibs() {
+    if !cfg.Opt.Called("shlibs") {
+        cfg.Shlibs = ""
+        return
+    }
+    if cfg.Shlibs != "report" && cfg.Shlibs != "rebuild" {
+        fmt.Fprintf(os.Stderr, "ERROR: %s is not a valid shlibs mode (must be report or rebuild).\n", cfg.Shlibs)
+        os.Exit(1)
+    }
+    if cfg.Shlibs == "rebuild" && (cfg.Universe || cfg.Resume) {
+        fmt.Fprintf(os.Stderr, "ERROR: Shlib rebuilds cannot be combined with universe or resuming.\n")
+        os.Exit(1)
+    }
+}
+
+// Validate that the chosen executor has what it needs
+func (cfg *Cfgs) ValidExecutor() {
+    if cfg.Executor == "container" && cfg.ContainerImage == "" {
+        fmt.Fprintf(os.Stderr, "ERROR: The container executor needs an image in [executor.container].\n")
+        os.Exit(1)
+    }
+    // Anyone who can reach the coordinator can be handed jobs and upload
+    // binpkgs, so only let that be anyone on this machine
+    if cfg.Executor == "remote" && cfg.RemoteToken == "" && !loopback(cfg.RemoteListen) {
+        fmt.Fprintf(os.Stderr, "ERROR: The remote executor needs a token in [executor.remote] to listen on %s.\n",
+            cfg.RemoteListen)
+        os.Exit(1)
+    }
+}
+
+// Check if an address to listen on is only reachable from this machine
+func loopback(address string) bool {
+    host, _, err := net.SplitHostPort(address)
+    if err != nil {
+        return false
+    }
+    if host == "localhost" {
+        return true
+    }
+    ip := net.ParseIP(host)
+    return ip != nil && ip.IsLoopback()
+}
+
+// Validate the way masterdirs are snapshotted
+func (cfg *Cfgs) ValidSnapshot() {
+    if cfg.Snapshot != "none" && cfg.Snapshot != "reflink" && cfg.Snapshot != "overlay" {
+        fmt.Fprintf(os.Stderr, "ERROR: %s is not a valid snapshot type (none, reflink or overlay).\n", cfg.Snapshot)
+        os.Exit(1)
+    }
+    // Mounting an overlay needs root, unlike the mounts mnthelper makes
+    if cfg.Snapshot == "overlay" && os.Geteuid() != 0 {
+        fmt.Fprintf(os.Stderr, "ERROR: overlay snapshots need vxb to be run as root, use reflink instead.\n")
+        os.Exit(1)
+    }
+    // Containers and Nomad jobs make their own masterdirs from scratch
+    if cfg.Snapshot != "none" && (cfg.Executor == "container" || cfg.Executor == "nomad") {
+        fmt.Fprintf(os.Stderr, "ERROR: Snapshots can't be used with the %s executor.\n", cfg.Executor)
+        os.Exit(1)
+    }
+}
+
+// Validate that we are building at least one package at a time
+func (cfg *Cfgs) ValidJobs() {
+    if cfg.Jobs < 1 {
+        fmt.Fprintf(os.Stderr, "ERROR: %d is not a valid number of jobs (must be at least 1).\n", cfg.Jobs)
+        os.Exit(1)
+    }
+}
+
 // Validate that git commits and git enabled make sense
 func (cfg *Cfgs) ValidGitEnabled() {
     // If git commits were given on command line options, git must be enabled
@@ -443,18 +928,30 @@ func (cfg *Cfgs) ValidGitEnabled() {
     }
 }
 
-// Validate arch and hostArch are known
-func (cfg *Cfgs) validateArch() {
-    // Check arch
-    archFound := false
-    for _, tArch := range validArchs {
-        if tArch == cfg.Arch {
-            archFound = true
-            break
+// Expand the architectures to build for
+// Each may be a glob, which is matched against every valid arch.
+func (cfg *Cfgs) EvalArches() {
+    seen := make(map[string]bool)
+    for _, pattern := range str.FieldsFunc(cfg.Arch, func(r rune) bool {
+        return r == ' ' || r == ','
+    }) {
+        found := false
+        for _, arch := range validArchs {
+            if glob.Glob(pattern, arch) {
+                found = true
+                if !seen[arch] {
+                    seen[arch] = true
+                    cfg.Arches = append(cfg.Arches, arch)
+                }
+            }
+        }
+        if !found {
+            fmt.Fprintf(os.Stderr, "ERROR: %s is not a valid architecture.\n", pattern)
+            os.Exit(1)
         }
     }
-    if !archFound {
-        fmt.Fprintf(os.Stderr, "ERROR: %s is not a valid architecture.\n", cfg.Arch)
+    if len(cfg.Arches) == 0 {
+        fmt.Fprintf(os.Stderr, "ERROR: No architecture to build for was given.\n")
         os.Exit(1)
     }
 }
@@ -480,9 +977,11 @@ func (cfg *Cfgs) validateMountSizes() {
 
 // We must be given *something* to do
 func (cfg *Cfgs) validDo() {
-    // Either a package must be given or git commit must be given
-    if !cfg.Opt.Called("pkgname") && !cfg.Opt.Called("git") {
-        fmt.Fprintf(os.Stderr, "ERROR: Either packages to build or git must be specified.")
+    // Either a package must be given, git commit must be given, or
+    // everything is being built
+    if !cfg.Opt.Called("pkgname") && !cfg.Opt.Called("git") && !cfg.Universe &&
+        !cfg.Opt.Called("shlibs") {
+        fmt.Fprintf(os.Stderr, "ERROR: Either packages to build, git, universe or shlibs must be specified.")
         os.Exit(1)
     }
 }
@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package cfg
+
+import (
+    "github.com/go-ini/ini"
+    "testing"
+)
+
+func TestParseSubrepo(t *testing.T) {
+    tests := []struct {
+        name string
+        section string
+        want map[string]string
+    }{
+        {"none", "", map[string]string{}},
+        {"x86_64 shared with i686", "x86_64 = nonfree\n",
+            map[string]string{"x86_64": "nonfree", "i686": "nonfree"}},
+        {"i686 set on purpose", "x86_64 = nonfree\ni686 = other\n",
+            map[string]string{"x86_64": "nonfree", "i686": "other"}},
+        {"only i686", "i686 = nonfree\n", map[string]string{"i686": "nonfree"}},
+        {"glob", "x86* = nonfree\n", map[string]string{"x86_64": "nonfree", "i686": "nonfree"}},
+        {"other arch", "aarch64 = nonfree\n", map[string]string{"aarch64": "nonfree"}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            cfgf, err := ini.Load([]byte("[vpkg.subrepo]\n" + test.section))
+            if err != nil {
+                t.Fatal(err)
+            }
+            cfg := Cfgs{cfgf: cfgf}
+            cfg.parseSubrepo()
+            if len(cfg.SubRepos) != len(test.want) {
+                t.Errorf("subrepos are %v, want %v", cfg.SubRepos, test.want)
+            }
+            for arch, repo := range test.want {
+                if cfg.SubRepos[arch] != repo {
+                    t.Errorf("subrepo of %s is %q, want %q", arch, cfg.SubRepos[arch], repo)
+                }
+            }
+        })
+    }
+}
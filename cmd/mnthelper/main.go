@@ -119,7 +119,7 @@ func main() {
             }
             err = os.Chown(vpkgPath + "/mnt/tmpfs", owner, -1)
             if err != nil {
-                panic(fmt.Errorf("Error %w occurred chowning %s to %s", err, vpkgPath + "/mnt/tmpfs", owner))
+                panic(fmt.Errorf("Error %w occurred chowning %s to %d", err, vpkgPath + "/mnt/tmpfs", owner))
             }
         }
     }
@@ -140,7 +140,7 @@ func main() {
             }
             err = os.Chown(vpkgPath + "/mnt/zram", owner, -1)
             if err != nil {
-                panic(fmt.Errorf("Error %w occurred chowning %s to %s", err, vpkgPath + "/mnt/zram", owner))
+                panic(fmt.Errorf("Error %w occurred chowning %s to %d", err, vpkgPath + "/mnt/zram", owner))
             }
         }
     }
@@ -161,7 +161,7 @@ func main() {
             }
             err = os.Chown(vpkgPath + "/mnt/zram-zstd", owner, -1)
             if err != nil {
-                panic(fmt.Errorf("Error %w occurred chowning %s to %s", err, vpkgPath + "/mnt/zram-zstd", owner))
+                panic(fmt.Errorf("Error %w occurred chowning %s to %d", err, vpkgPath + "/mnt/zram-zstd", owner))
             }
         }
     }
@@ -170,7 +170,7 @@ func main() {
     if !unmount {
         err = os.Chown(vpkgPath + "/mnt", owner, -1)
         if err != nil {
-            panic(fmt.Errorf("Error %w occurred chowning %s to %s", err, vpkgPath + "/mnt", owner))
+            panic(fmt.Errorf("Error %w occurred chowning %s to %d", err, vpkgPath + "/mnt", owner))
         }
     }
 
@@ -178,7 +178,7 @@ func main() {
     if unmount {
         err = os.RemoveAll(vpkgPath + "/mnt")
         if err != nil {
-            panic(fmt.Errorf("Error %w occured removing %s", vpkgPath + "/mnt"))
+            panic(fmt.Errorf("Error %w occured removing %s", err, vpkgPath + "/mnt"))
         }
     }
 }
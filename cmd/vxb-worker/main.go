@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+/*
+ * This is a remote worker, which builds packages handed out by a vxb
+ * coordinator (the remote executor) and sends the binpkgs back.
+ */
+
+package main
+
+import (
+    "github.com/fosslinux/vxb/build"
+    "github.com/fosslinux/vxb/cfg"
+    getoptions "github.com/DavidGamba/go-getoptions"
+    "github.com/go-ini/ini"
+    "golang.org/x/sys/unix"
+    "fmt"
+    "os"
+    str "strings"
+)
+
+// Main function
+func main() {
+    var err error
+
+    // Option parsing
+    opt := getoptions.New()
+    opt.SetMode(getoptions.Bundling)
+    opt.Bool("help", false, opt.Alias("h"))
+    var coordinator string
+    opt.StringVar(&coordinator, "coordinator", "", opt.Alias("C"),
+        opt.Description("URL of the vxb coordinator (e.g. http://builder:8765)."))
+    var vpkgPath string
+    opt.StringVar(&vpkgPath, "vpkg", "", opt.Alias("v"),
+        opt.Description("Path to void-packages checkout."))
+    var hostArch string
+    opt.StringVar(&hostArch, "hostarch", "", opt.Alias("m"),
+        opt.Description("The host architecture."))
+    var name string
+    opt.StringVar(&name, "name", "", opt.Alias("n"),
+        opt.Description("Name of this worker (default hostname)."))
+    var token string
+    opt.StringVar(&token, "token", "", opt.Alias("t"),
+        opt.Description("Shared secret of the coordinator."))
+    var confPath string
+    opt.StringVar(&confPath, "conf", "conf.ini", opt.Alias("c"),
+        opt.Description("Configuration file path."))
+    remaining, err := opt.Parse(os.Args[1:])
+    if err != nil {
+        panic(fmt.Errorf("Error %w while parsing options", err))
+    }
+
+    // Process help
+    if opt.Called("help") {
+        fmt.Fprintf(os.Stderr, opt.Help())
+        os.Exit(0)
+    }
+
+    // Warn about unhandled arguments
+    if len(remaining) != 0 {
+        fmt.Fprintf(os.Stderr, "WARN: Unhandled arguments: %v\n", remaining)
+    }
+
+    // The config file is optional, but fills in anything not given
+    iniF := ini.Empty()
+    _, exists := os.Stat(confPath)
+    if !os.IsNotExist(exists) {
+        iniF, err = ini.Load(confPath)
+        if err != nil {
+            panic(fmt.Errorf("Error %w encountered while loading config file", err))
+        }
+    } else if opt.Called("conf") {
+        fmt.Fprintf(os.Stderr, "ERROR: Config file %s does not exist.\n", confPath)
+        os.Exit(1)
+    }
+    if !opt.Called("vpkg") {
+        vpkgPath = iniF.Section("vpkg").Key("path").String()
+    }
+    if !opt.Called("hostarch") {
+        hostArch = iniF.Section("vpkg").Key("host_arch").String()
+    }
+    if !opt.Called("token") {
+        token = iniF.Section("executor.remote").Key("token").String()
+    }
+    snapshot := iniF.Section("build").Key("snapshot").MustString("none")
+
+    // We must have a coordinator and vpkgPath
+    if coordinator == "" {
+        fmt.Fprintf(os.Stderr, "ERROR: No coordinator provided.\n")
+        os.Exit(1)
+    }
+    if vpkgPath == "" {
+        fmt.Fprintf(os.Stderr, "ERROR: No void-packages path provided.\n")
+        os.Exit(1)
+    }
+
+    // Default to what we are running on
+    if hostArch == "" || name == "" {
+        sysInfo := unix.Utsname{}
+        err = unix.Uname(&sysInfo)
+        if err != nil {
+            panic(fmt.Errorf("Error %w getting information about system", err))
+        }
+        if hostArch == "" {
+            hostArch = str.TrimRight(string(sysInfo.Machine[:]), "\x00")
+        }
+        if name == "" {
+            name = str.TrimRight(string(sysInfo.Nodename[:]), "\x00")
+        }
+    }
+
+    workerCfg := cfg.Cfgs{VpkgPath: vpkgPath, HostArch: hostArch, Masterdir: "masterdir",
+        MountDefault: "none", RemoteToken: token, Snapshot: snapshot}
+    workerCfg.ValidSnapshot()
+    err = build.RunWorker(coordinator, name, workerCfg)
+    if err != nil {
+        panic(err)
+    }
+}
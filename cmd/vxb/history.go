@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package main
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "github.com/fosslinux/vxb/history"
+    "fmt"
+    "os"
+    "text/tabwriter"
+    "time"
+)
+
+// Parse a time given on the command line
+// It is either a time (RFC3339 or a date) or how long ago (e.g. 24h).
+func parseWhen(when string) (time.Time, error) {
+    ago, err := time.ParseDuration(when)
+    if err == nil {
+        return time.Now().Add(-ago), nil
+    }
+    t, err := time.Parse(time.RFC3339, when)
+    if err == nil {
+        return t, nil
+    }
+    t, err = time.ParseInLocation("2006-01-02", when, time.Local)
+    if err == nil {
+        return t, nil
+    }
+    return t, fmt.Errorf("Invalid time %s (expected RFC3339, YYYY-MM-DD or a duration)", when)
+}
+
+// Query the build history (vxb history)
+func historyMain(args []string) {
+    var err error
+
+    // Only the options for querying, but the same config file as vxb
+    cfg := cfg.Cfgs{}
+    cfg.InitOpt()
+    opt := cfg.Opt
+    opt.Bool("help", false, opt.Alias("h"))
+    query := history.Query{}
+    opt.StringVar(&query.Pkgname, "pkgname", "", opt.Alias("p"),
+        opt.Description("Only show this package."))
+    opt.StringVar(&query.Arch, "arch", "", opt.Alias("a"),
+        opt.Description("Only show this architecture."))
+    opt.StringVar(&query.Status, "status", "", opt.Alias("s"),
+        opt.Description("Only show attempts that ended like this (success, failed or timeout)."))
+    var since, until string
+    opt.StringVar(&since, "since", "", opt.Alias("S"),
+        opt.Description("Only show attempts started at or after this time (RFC3339, YYYY-MM-DD or e.g. 24h ago)."))
+    opt.StringVar(&until, "until", "", opt.Alias("U"),
+        opt.Description("Only show attempts started before this time (RFC3339, YYYY-MM-DD or e.g. 24h ago)."))
+    opt.StringVar(&cfg.HistoryPath, "db", cfg.HistoryPath, opt.Alias("d"),
+        opt.Description("History database path (default from the config file)."))
+    opt.StringVar(&cfg.ConfPath, "conf", "conf.ini", opt.Alias("c"),
+        opt.Description("Configuration file path."))
+    cfg.ActOpts(opt.Parse(args))
+
+    // The database is where vxb puts it, unless told otherwise
+    // Nothing else in the config file matters for a query.
+    hasCfg, err := cfg.InitCfg()
+    if err != nil {
+        panic(err)
+    }
+    if hasCfg {
+        cfg.ParseHistoryCfg()
+    }
+
+    // Validate the query
+    switch query.Status {
+        case "", history.StatusSuccess, history.StatusFailed, history.StatusTimeout:
+        default:
+            fmt.Fprintf(os.Stderr, "ERROR: Invalid status %s.\n", query.Status)
+            os.Exit(1)
+    }
+    if since != "" {
+        query.Since, err = parseWhen(since)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "ERROR: %s.\n", err)
+            os.Exit(1)
+        }
+    }
+    if until != "" {
+        query.Until, err = parseWhen(until)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "ERROR: %s.\n", err)
+            os.Exit(1)
+        }
+    }
+
+    if cfg.HistoryPath == "" {
+        fmt.Fprintf(os.Stderr, "ERROR: Build history is disabled.\n")
+        os.Exit(1)
+    }
+    _, exists := os.Stat(cfg.HistoryPath)
+    if os.IsNotExist(exists) {
+        fmt.Fprintf(os.Stderr, "ERROR: History database %s does not exist.\n", cfg.HistoryPath)
+        os.Exit(1)
+    }
+
+    records, err := history.Open(cfg.HistoryPath).Find(query)
+    if err != nil {
+        panic(err)
+    }
+
+    w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+    fmt.Fprintf(w, "STARTED\tPACKAGE\tVERSION\tDURATION\tSTATUS\tEXIT\tMOUNT\tCOMMIT\tLOG\n")
+    for _, record := range records {
+        commit := record.Commit
+        if len(commit) > 12 {
+            commit = commit[:12]
+        }
+        fmt.Fprintf(w, "%s\t%s@%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+            record.Started.Local().Format("2006-01-02 15:04:05"), record.Pkgname, record.Arch,
+            record.Version, record.Duration.Round(time.Second), record.Status, record.ExitStatus,
+            record.MountType, commit, record.LogPath)
+    }
+    w.Flush()
+}
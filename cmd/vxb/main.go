@@ -8,13 +8,13 @@ import (
     "github.com/fosslinux/vxb/graph"
     "github.com/fosslinux/vxb/git"
     "github.com/fosslinux/vxb/cfg"
+    "github.com/fosslinux/vxb/vpkgs"
     "os"
     "fmt"
+    "io"
     str "strings"
 )
 
-// TODO: A universe build flag will be required for Void that builds all packages.
-
 // Remove an element from a []string
 func sStringRm(s []string, i int) []string {
     s[len(s)-1], s[i] = s[i], s[len(s)-1]
@@ -33,48 +33,96 @@ func delete_empty(s []string) []string {
 	return r
 }
 
-// Generate a package list
-func genPkgList(cfg cfg.Cfgs) ([]string, error) {
-    var pkgNames []string
+// Generate a package list for each arch
+func genPkgList(cfg cfg.Cfgs) (map[string][]string, error) {
+    pkgNames := make(map[string][]string)
     var err error
 
     // Generate the list of packages
-    if cfg.Opt.Called("git") {
+    if cfg.Universe {
+        // Everything that is not already ready
+        for _, arch := range cfg.Arches {
+            pkgNames[arch], err = vpkgs.Universe(arch, cfg)
+            if err != nil {
+                return pkgNames, err
+            }
+        }
+    } else if cfg.Opt.Called("git") {
         // Generate the updated packages between these commits
-        pkgNames, err = git.Changed(cfg.Arch, cfg, str.Split(cfg.Git.Commits, "...")...)
+        pkgNames, err = git.Changed(cfg.Arches, cfg, str.Split(cfg.Git.Commits, "...")...)
         if err != nil {
-            return []string{}, err
+            return pkgNames, err
         }
         // If we specificed package names as well it is the intersection of
         // those and the updated packages.
         if cfg.Opt.Called("pkgname") {
             validPkgNames := str.Split(cfg.SPkgNames, " ")
-            for i, pkgA := range pkgNames {
-                found := false
-                for _, pkgB := range validPkgNames {
-                    if pkgA == pkgB {
-                        found = true
+            for arch, archPkgNames := range pkgNames {
+                for i, pkgA := range archPkgNames {
+                    found := false
+                    for _, pkgB := range validPkgNames {
+                        if pkgA == pkgB {
+                            found = true
+                        }
+                    }
+                    if !found {
+                        archPkgNames[i] = ""
                     }
                 }
-                if !found {
-                    pkgNames[i] = ""
-                }
+                // Now delete all of the empty ones
+                pkgNames[arch] = delete_empty(archPkgNames)
             }
-            // Now delete all of the empty ones
-            pkgNames = delete_empty(pkgNames)
         }
     } else {
         // Then its just package names from the command line
-        pkgNames = str.Split(cfg.SPkgNames, " ")
+        for _, arch := range cfg.Arches {
+            pkgNames[arch] = delete_empty(str.Split(cfg.SPkgNames, " "))
+        }
     }
 
     return pkgNames, nil
 }
 
+// Print the packages requiring shlibs nothing provides
+func reportShlibs(w io.Writer, consumers []vpkgs.ShlibConsumer) {
+    fmt.Fprintf(w, "%d package(s) require shlibs that are not provided:\n", len(consumers))
+    for _, consumer := range consumers {
+        fmt.Fprintf(w, "  %s (%s) requires %s", consumer.Pkgver, consumer.BasePkg,
+            str.Join(consumer.Missing, " "))
+        if len(consumer.Providers) != 0 {
+            fmt.Fprintf(w, ", now from %s", str.Join(consumer.Providers, " "))
+        }
+        fmt.Fprintf(w, "\n")
+    }
+}
+
+// Base packages of the consumers not already being rebuilt
+func shlibRebuilds(consumers []vpkgs.ShlibConsumer, already []string) []string {
+    var pkgNames []string
+    seen := make(map[string]bool)
+    for _, pkgName := range already {
+        seen[pkgName] = true
+    }
+    for _, consumer := range consumers {
+        if seen[consumer.BasePkg] {
+            continue
+        }
+        seen[consumer.BasePkg] = true
+        pkgNames = append(pkgNames, consumer.BasePkg)
+    }
+    return pkgNames
+}
+
 // Main function
 func main() {
     var err error
 
+    // vxb history has its own options
+    if len(os.Args) > 1 && os.Args[1] == "history" {
+        historyMain(os.Args[2:])
+        return
+    }
+
     // Initalize configuration struct
     cfg := cfg.Cfgs{}
 
@@ -101,10 +149,31 @@ func main() {
     }
 
     // Evaluate bits and pieces
+    cfg.EvalArches()
     cfg.EvalAutoMuslExt()
+    cfg.EvalCachePath()
 
     // Perform validations
     cfg.ValidGitEnabled()
+    cfg.ValidJobs()
+    cfg.ValidUniverse()
+    cfg.ValidRevdeps()
+    cfg.ValidShlibs()
+    cfg.ValidExecutor()
+    cfg.ValidSnapshot()
+
+    // Keep stdout clean for a JSON plan; progress goes to stderr instead
+    var progress io.Writer = os.Stdout
+    if cfg.Plan && cfg.JSON {
+        progress = os.Stderr
+    }
+
+    // Every masterdir from here on, including those used to generate the
+    // graph, is cloned from the pristine one
+    err = vpkgs.RefreshPristine(progress, cfg)
+    if err != nil {
+        panic(err)
+    }
 
     // Warn if there are modifications NOT being made by default (and we
     // haven't already)
@@ -113,23 +182,81 @@ func main() {
     }
 
     // Do the actual build
-    pkgNames, err := genPkgList(cfg)
-    if err != nil {
-        panic(err)
+    var pkgGraph graph.Graph
+    if cfg.Resume {
+        fmt.Fprintf(progress, "Loading graph from %s...\n", cfg.StatePath)
+        pkgGraph, err = graph.Load(cfg.StatePath, cfg)
+        if err != nil {
+            panic(err)
+        }
+    } else {
+        pkgNames, err := genPkgList(cfg)
+        if err != nil {
+            panic(err)
+        }
+
+        force := make(map[string][]string)
+        for _, arch := range cfg.Arches {
+            // Everything depending on the packages is rebuilt
+            if cfg.Revdeps {
+                force[arch], err = vpkgs.Revdeps(pkgNames[arch], arch, progress, cfg)
+                if err != nil {
+                    panic(err)
+                }
+                fmt.Fprintf(progress, "Rebuilding %d reverse dependencies for %s...\n", len(force[arch]), arch)
+            }
+
+            // Packages whose shlibs have gone away
+            if cfg.Shlibs != "" {
+                consumers, err := vpkgs.ShlibConsumers(arch, cfg)
+                if err != nil {
+                    panic(err)
+                }
+                fmt.Fprintf(progress, "%s: ", arch)
+                reportShlibs(progress, consumers)
+                force[arch] = append(force[arch], shlibRebuilds(consumers, force[arch])...)
+            }
+        }
+        if cfg.Shlibs == "report" {
+            return
+        }
+
+        fmt.Fprintf(progress, "Generating graph...\n")
+        pkgGraph, err = graph.Generate(pkgNames, force, progress, cfg)
+        if err != nil {
+            panic(err)
+        }
+        // A plan leaves the state of an interrupted run alone
+        if !cfg.Plan {
+            err = pkgGraph.Save(cfg.StatePath, cfg)
+            if err != nil {
+                panic(err)
+            }
+        }
     }
 
-    fmt.Printf("Generating graph...\n")
-    pkgGraph, err := graph.Generate(pkgNames, cfg)
-    if err != nil {
-        panic(err)
+    // Only show what we would do
+    if cfg.Plan {
+        durations, err := graph.PastDurations(cfg)
+        if err != nil {
+            panic(err)
+        }
+        err = pkgGraph.PrintPlan(os.Stdout, cfg.JSON, durations)
+        if err != nil {
+            panic(err)
+        }
+        return
     }
+
     err = pkgGraph.DagToDot("graph.dot")
     if err != nil {
         panic(err)
     }
 
     err = pkgGraph.Build(cfg)
+    pkgGraph.Summary()
     if err != nil {
-        panic(err)
+        fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+        os.Exit(1)
     }
 }
@@ -9,11 +9,11 @@ import (
     "fmt"
 )
 
-func Changed(arch string, cfg cfg.Cfgs, commits ...string) ([]string, error) {
+func Changed(arches []string, cfg cfg.Cfgs, commits ...string) (map[string][]string, error) {
     r := cfg.Git
 
     var err error
-    errRet := []string{}
+    errRet := make(map[string][]string)
 
     // We only support:
     // 0 arguments: diff from current checkout to remote
@@ -57,12 +57,13 @@ func Changed(arch string, cfg cfg.Cfgs, commits ...string) ([]string, error) {
         }
     }
 
-    return changedAb(arch, commita, commitb, cfg)
+    return changedAb(arches, commita, commitb, cfg)
 }
 
-func changedAb(arch string, commita string, commitb string, cfg cfg.Cfgs) ([]string, error) {
+// Get the outdated packages of each arch at commitb
+func changedAb(arches []string, commita string, commitb string, cfg cfg.Cfgs) (map[string][]string, error) {
     var err error
-    errRet := []string{}
+    errRet := make(map[string][]string)
 
     // Perform a sanity check - commita should have NO not up-to-date packages
     // Only test this if we are not going from HEAD
@@ -71,23 +72,31 @@ func changedAb(arch string, commita string, commitb string, cfg cfg.Cfgs) ([]str
         if err != nil {
             return errRet, err
         }
-        ready, notReadyPkgs, err := vpkgs.Ready(arch, cfg)
-        if err != nil {
-            return errRet, err
-        }
-        if !ready {
-            fmt.Printf("%v\n", notReadyPkgs)
-            return errRet, fmt.Errorf("%s (commit to go from) must NOT have any outdated packages (listed above)!")
+        // The tree changed under checkvers
+        vpkgs.InvalidateAllReady()
+        for _, arch := range arches {
+            ready, notReadyPkgs, err := vpkgs.Ready(arch, cfg)
+            if err != nil {
+                return errRet, err
+            }
+            if !ready {
+                fmt.Printf("%v\n", notReadyPkgs)
+                return errRet, fmt.Errorf("%s (commit to go from) must NOT have any outdated packages for %s (listed above)!", commita, arch)
+            }
         }
     }
 
     // Checkout commitb
     err = rebase(commitb, cfg)
+    vpkgs.InvalidateAllReady()
 
     // Check for outdated packges
-    _, outdated, err := vpkgs.Ready(arch, cfg)
-    if err != nil {
-        return outdated, err
+    outdated := make(map[string][]string)
+    for _, arch := range arches {
+        _, outdated[arch], err = vpkgs.Ready(arch, cfg)
+        if err != nil {
+            return outdated, err
+        }
     }
 
     return outdated, nil
@@ -196,3 +196,15 @@ func changeGood(rErr error, initRun bool, cfg cfg.Cfgs) error {
 
     return nil
 }
+
+// Get the commit void-packages is at, or nothing if it is not a git checkout
+// This does not need git to be enabled, and is safe to use while building.
+func Head(cfg cfg.Cfgs) string {
+    cmd := exec.Command("git", "rev-parse", "HEAD")
+    cmd.Dir = cfg.VpkgPath
+    out, err := cmd.Output()
+    if err != nil {
+        return ""
+    }
+    return str.TrimSpace(string(out[:]))
+}
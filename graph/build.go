@@ -5,71 +5,248 @@
 package graph
 
 import (
-    "github.com/goombaio/dag"
     "github.com/fosslinux/vxb/build"
     "github.com/fosslinux/vxb/cfg"
+    "github.com/fosslinux/vxb/git"
+    "github.com/fosslinux/vxb/history"
+    "github.com/fosslinux/vxb/util"
+    "github.com/fosslinux/vxb/vpkgs"
+    "errors"
     "fmt"
+    "os"
+    "time"
+    str "strings"
 )
 
-// Build the children of a vertex
-func (graphS Graph) children(vertex *dag.Vertex, cfg cfg.Cfgs) error {
-    graph := graphS.g
-    pkgs := graphS.pkgs
+// Result of building a single vertex
+type buildResult struct {
+    ident string
+    err error
+}
 
-    var err error
+// Where build attempts are recorded
+type buildHistory struct {
+    db *history.DB
+    // Commit of void-packages being built
+    commit string
+}
 
-    // Loop over children
-    children, err := graph.Successors(vertex)
-    if err != nil {
-        return fmt.Errorf("Unable to get children of %s with %w", vertex.ID, err)
-    }
-    for _, child := range children {
-        // Check if we are ready
-        if pkgs[child.ID].Ready {
-            // We don't need to do anything
+// Build packages given to us until there are none left
+func (graphS Graph) buildWorker(executor build.Executor, runLogs *build.RunLogs, hist *buildHistory, cfg cfg.Cfgs, idents <-chan string, results chan<- buildResult) {
+    for ident := range idents {
+        fmt.Printf("Building %s...\n", ident)
+        log, err := runLogs.Open(ident, os.Stdout)
+        if err != nil {
+            results <- buildResult{ident: ident, err: err}
             continue
         }
+        err = build.Build(executor, ident, graphS.force[ident], log, cfg)
+        closeErr := log.Close()
+        graphS.record(hist, ident, log)
+        if err == nil {
+            err = closeErr
+        } else if log.Path() != "" {
+            err = fmt.Errorf("%w (log in %s)", err, log.Path())
+        }
+        results <- buildResult{ident: ident, err: err}
+    }
+}
 
-        // Children first again!
-        err = graphS.children(child, cfg)
+// Record the attempts at building a package in the history
+// Failing to do so is not worth failing the build over.
+func (graphS Graph) record(hist *buildHistory, ident string, log *build.Log) {
+    if hist == nil {
+        return
+    }
+    splitIdent := str.Split(ident, "@")
+    for _, attempt := range log.Attempts() {
+        record := history.Record{
+            Pkgname: splitIdent[0],
+            Arch: splitIdent[1],
+            Version: graphS.pkgs[ident].Version,
+            Commit: hist.commit,
+            Started: attempt.Started,
+            Duration: attempt.Finished.Sub(attempt.Started),
+            Status: history.StatusSuccess,
+            ExitStatus: attempt.Status,
+            MountType: attempt.MountType,
+            LogPath: log.Path(),
+        }
+        if errors.Is(attempt.Err, util.ErrTimeout) {
+            record.Status = history.StatusTimeout
+        } else if attempt.Err != nil {
+            record.Status = history.StatusFailed
+        }
+        err := hist.db.Add(record)
         if err != nil {
-            return err
+            fmt.Fprintf(os.Stderr, "WARN: Unable to record history of %s: %s\n", ident, err)
         }
+    }
+}
 
-        // Build this package
-        fmt.Printf("Building %s (pulled in by %s)...\n", child.ID, vertex.ID)
-        err = build.Build(child.ID, cfg)
+// Get the past build durations from the history, if there is one
+func PastDurations(cfg cfg.Cfgs) (map[string]time.Duration, error) {
+    durations := make(map[string]time.Duration)
+    if cfg.HistoryPath == "" {
+        return durations, nil
+    }
+    return history.Open(cfg.HistoryPath).LastDurations()
+}
+
+// Configuration of a worker
+// Each worker needs its own masterdir if there are several.
+func workerCfg(i int, cfg cfg.Cfgs) cfg.Cfgs {
+    if cfg.Jobs > 1 {
+        cfg.Masterdir = fmt.Sprintf("masterdir-%d", i)
+    }
+    return cfg
+}
+
+// Share a noarch package that was built for the host with the target arches
+func (graphS Graph) shareNoarch(ident string, cfg cfg.Cfgs) error {
+    pkg := graphS.pkgs[ident]
+    if !pkg.Noarch {
+        return nil
+    }
+    for _, arch := range cfg.Arches {
+        if arch == cfg.HostArch {
+            continue
+        }
+        err := vpkgs.RegisterNoarch(str.Split(ident, "@")[0], *pkg, arch, cfg)
         if err != nil {
             return err
         }
-
-        pkgs[child.ID].Ready = true
     }
-
     return nil
 }
 
 // Build packages in graph
+// Up to cfg.Jobs packages are built at once, each by its own executor (and
+// so in its own masterdir). A package is only built once everything it
+// depends on (host or target) has been built. If cfg.KeepGoing is set, a
+// failure only stops the packages that depend on the failed one from being
+// built.
 func (graphS Graph) Build(cfg cfg.Cfgs) error {
     graph := graphS.g
-    var err error
 
-    // Loop over source vertices (which are never ready)
-    for _, vertex := range graph.SourceVertices() {
-        // Children first
-        err = graphS.children(vertex, cfg)
+    // Nothing depending on an unbuildable package can be built either
+    for _, ident := range graphS.withStatus(StatusUnbuildable) {
+        vertex, err := graph.GetVertex(ident)
+        if err != nil {
+            return fmt.Errorf("Error %w getting vertex %s", err, ident)
+        }
+        err = graphS.unbuildableParents(vertex)
         if err != nil {
             return err
         }
+    }
 
-        // Now we can build
-        fmt.Printf("Building %s...\n", vertex.ID)
-        err = build.Build(vertex.ID, cfg)
+    // Create the workers' executors
+    var executors []build.Executor
+    for i := 0; i < cfg.Jobs; i++ {
+        executor, err := build.NewExecutor(workerCfg(i, cfg))
         if err != nil {
             return err
         }
-        graphS.pkgs[vertex.ID].Ready = true
+        executors = append(executors, executor)
     }
 
+    // Each package's output also goes to a log file
+    runLogs, err := build.NewRunLogs(cfg)
+    if err != nil {
+        return err
+    }
+
+    // Every attempt is recorded in the history
+    var hist *buildHistory
+    if cfg.HistoryPath != "" {
+        hist = &buildHistory{db: history.Open(cfg.HistoryPath), commit: git.Head(cfg)}
+    }
+
+    // The longest chains are started first, going by how long they took before
+    durations := make(map[string]time.Duration)
+    if hist != nil {
+        durations, err = hist.db.LastDurations()
+        if err != nil {
+            return err
+        }
+    }
+    q := graphS.newQueue(durations)
+    q.printETA(cfg.Jobs)
+
+    // Start the workers
+    idents := make(chan string, cfg.Jobs)
+    results := make(chan buildResult, cfg.Jobs)
+    for i := 0; i < cfg.Jobs; i++ {
+        go graphS.buildWorker(executors[i], runLogs, hist, workerCfg(i, cfg), idents, results)
+    }
+    defer close(idents)
+
+    var buildErr error
+    running := 0
+    for !q.empty() || running > 0 {
+        // Hand out as much as we can
+        for running < cfg.Jobs && !q.empty() {
+            idents <- q.pop()
+            running++
+        }
+
+        // Wait for something to finish
+        res := <-results
+        running--
+
+        if res.err != nil {
+            fmt.Fprintf(os.Stderr, "ERROR: %s\n", res.err)
+            vertex, err := graph.GetVertex(res.ident)
+            if err != nil {
+                return fmt.Errorf("Error %w getting vertex %s", err, res.ident)
+            }
+            graphS.status[res.ident] = StatusFailed
+            if errors.Is(res.err, util.ErrTimeout) {
+                graphS.status[res.ident] = StatusTimeout
+            }
+            err = graphS.skipParents(vertex)
+            if err != nil {
+                return err
+            }
+            if !cfg.KeepGoing {
+                // Don't start anything new, but let the others finish
+                if buildErr == nil {
+                    buildErr = res.err
+                }
+                q.clear()
+            }
+            continue
+        }
+        graphS.pkgs[res.ident].Ready = true
+        graphS.status[res.ident] = StatusBuilt
+        // Failures are retried on --resume anyway, so only this is saved
+        err := saveBuilt(cfg.StatePath, res.ident)
+        if err != nil {
+            return err
+        }
+
+        // noarch packages are shared with the target arch
+        err = graphS.shareNoarch(res.ident, cfg)
+        if err != nil {
+            return err
+        }
+
+        // Anything depending on this may now be buildable
+        if buildErr == nil {
+            err = q.built(res.ident)
+            if err != nil {
+                return err
+            }
+            q.printETA(cfg.Jobs)
+        }
+    }
+
+    if buildErr != nil {
+        return buildErr
+    }
+    if failures := graphS.Failures(); failures != 0 {
+        return fmt.Errorf("%d package(s) failed or were skipped", failures)
+    }
     return nil
 }
@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package graph
+
+import (
+    "github.com/goombaio/dag"
+    "fmt"
+    "sort"
+    str "strings"
+)
+
+// A dependency cycle between packages
+type CycleError struct {
+    // pkgname@arch of each package in the cycle, starting and ending with
+    // the same package
+    Chain []string
+    // The kind of dependency of each edge in Chain
+    Kinds []string
+}
+
+func (cycleErr *CycleError) Error() string {
+    var sb str.Builder
+    sb.WriteString("Dependency cycle: ")
+    for i, ident := range cycleErr.Chain {
+        sb.WriteString(ident)
+        if i < len(cycleErr.Kinds) {
+            sb.WriteString(fmt.Sprintf(" -(%s)-> ", cycleErr.Kinds[i]))
+        }
+    }
+    // Tell the user how to get out of this
+    from := str.Split(cycleErr.Chain[0], "@")[0]
+    to := str.Split(cycleErr.Chain[1], "@")[0]
+    sb.WriteString(fmt.Sprintf(" (to break it, add %s = %s to [graph.ignore_edges])", from, to))
+    return sb.String()
+}
+
+// Find a dependency cycle in the graph, if there is one
+// This is one depth-first search over the whole graph, so it is done once
+// after graphing rather than for each edge as it is added. The edge out of
+// the last package walked to is the one given as the way to break the cycle.
+func (graphS Graph) findCycle() error {
+    // Vertices on the path being walked, and those with nothing left to walk
+    onPath := make(map[string]bool)
+    done := make(map[string]bool)
+    var path []string
+
+    var walk func(vertex *dag.Vertex) []string
+    walk = func(vertex *dag.Vertex) []string {
+        onPath[vertex.ID] = true
+        path = append(path, vertex.ID)
+        children, _ := graphS.g.Successors(vertex)
+        for _, child := range children {
+            if onPath[child.ID] {
+                // The path from child back to here, then to child again
+                start := 0
+                for path[start] != child.ID {
+                    start++
+                }
+                return append([]string{vertex.ID}, path[start:]...)
+            }
+            if done[child.ID] {
+                continue
+            }
+            chain := walk(child)
+            if chain != nil {
+                return chain
+            }
+        }
+        onPath[vertex.ID] = false
+        done[vertex.ID] = true
+        path = path[:len(path) - 1]
+        return nil
+    }
+
+    // Go in a stable order so the same cycle is always reported
+    var idents []string
+    for ident := range graphS.status {
+        idents = append(idents, ident)
+    }
+    sort.Strings(idents)
+    for _, ident := range idents {
+        if done[ident] {
+            continue
+        }
+        vertex, err := graphS.g.GetVertex(ident)
+        if err != nil {
+            return fmt.Errorf("Error %w getting vertex %s", err, ident)
+        }
+        chain := walk(vertex)
+        if chain == nil {
+            continue
+        }
+
+        cycleErr := CycleError{Chain: chain}
+        for i := 0; i < len(chain) - 1; i++ {
+            cycleErr.Kinds = append(cycleErr.Kinds, graphS.kinds[edge{chain[i], chain[i + 1]}])
+        }
+        return &cycleErr
+    }
+    return nil
+}
@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package graph
+
+import (
+    str "strings"
+    "testing"
+)
+
+func TestFindCycle(t *testing.T) {
+    idents := []string{"a@x86_64", "b@x86_64", "c@x86_64", "d@x86_64"}
+    tests := []struct {
+        name string
+        edges [][2]string
+        // Empty if there is no cycle
+        chain string
+        kinds string
+        // The dependency suggested to be ignored
+        ignore string
+    }{
+        {"no edges", nil, "", "", ""},
+        {"chain without cycle", [][2]string{{"a@x86_64", "b@x86_64"}, {"b@x86_64", "c@x86_64"},
+            {"a@x86_64", "c@x86_64"}}, "", "", ""},
+        {"diamond without cycle", [][2]string{{"a@x86_64", "b@x86_64"}, {"a@x86_64", "c@x86_64"},
+            {"b@x86_64", "d@x86_64"}, {"c@x86_64", "d@x86_64"}}, "", "", ""},
+        {"two packages", [][2]string{{"a@x86_64", "b@x86_64"}, {"b@x86_64", "a@x86_64"}},
+            "b@x86_64 a@x86_64 b@x86_64", "depends makedepends", "b = a"},
+        {"long cycle", [][2]string{{"a@x86_64", "b@x86_64"}, {"b@x86_64", "c@x86_64"},
+            {"c@x86_64", "d@x86_64"}, {"d@x86_64", "a@x86_64"}},
+            "d@x86_64 a@x86_64 b@x86_64 c@x86_64 d@x86_64",
+            "depends makedepends depends hostmakedepends", "d = a"},
+        {"cycle below an acyclic part", [][2]string{{"a@x86_64", "b@x86_64"}, {"a@x86_64", "c@x86_64"},
+            {"c@x86_64", "d@x86_64"}, {"d@x86_64", "c@x86_64"}},
+            "d@x86_64 c@x86_64 d@x86_64", "cmakedepends hostmakedepends", "d = c"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            graphS := testGraph(t, idents, test.edges)
+            graphS.kinds[edge{"a@x86_64", "b@x86_64"}] = "makedepends"
+            graphS.kinds[edge{"b@x86_64", "a@x86_64"}] = "depends"
+            graphS.kinds[edge{"b@x86_64", "c@x86_64"}] = "depends"
+            graphS.kinds[edge{"c@x86_64", "d@x86_64"}] = "hostmakedepends"
+            graphS.kinds[edge{"d@x86_64", "a@x86_64"}] = "depends"
+            graphS.kinds[edge{"d@x86_64", "c@x86_64"}] = "cmakedepends"
+
+            err := graphS.findCycle()
+            if test.chain == "" {
+                if err != nil {
+                    t.Errorf("unexpected cycle: %s", err)
+                }
+                return
+            }
+
+            cycleErr, ok := err.(*CycleError)
+            if !ok {
+                t.Fatalf("got %v, want a cycle", err)
+            }
+            if got := str.Join(cycleErr.Chain, " "); got != test.chain {
+                t.Errorf("chain is %q, want %q", got, test.chain)
+            }
+            if got := str.Join(cycleErr.Kinds, " "); got != test.kinds {
+                t.Errorf("kinds are %q, want %q", got, test.kinds)
+            }
+            // The message says how to break the cycle
+            if !str.Contains(err.Error(), "add " + test.ignore + " to [graph.ignore_edges]") {
+                t.Errorf("message is %q", err.Error())
+            }
+        })
+    }
+}
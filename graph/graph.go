@@ -10,6 +10,8 @@ import (
     "github.com/goombaio/dag"
     "fmt"
     "errors"
+    "io"
+    "os"
     str "strings"
 )
 
@@ -17,10 +19,75 @@ import (
 type Graph struct {
     g *dag.DAG
     pkgs map[string]*vpkgs.Pkg
+    status map[string]Status
+    // Hashes of templates, see vpkgs.TemplateHash
+    hashes map[string]string
+    // The package that first pulled in each vertex (empty if requested)
+    pulledBy map[string]string
+    // Vertices pulled in as host dependencies of a cross build
+    host map[string]bool
+    // The kind of dependency (hostmakedepends, etc) each edge is
+    kinds map[edge]string
+    // Why each unbuildable vertex can't be built
+    reasons map[string]string
+    // Vertices built even if they are ready in the repository
+    force map[string]bool
+    // Vertices whose noarch depends have been graphed for an arch, see
+    // noarchDepends
+    noarchDone map[string]bool
+    // Where progress while graphing is written
+    progress io.Writer
+}
+
+// An edge from a package to one of its dependencies
+type edge struct {
+    from string
+    to string
+}
+
+// Create an empty graph
+func newGraph() Graph {
+    graph := Graph{g: dag.NewDAG()}
+    graph.pkgs = make(map[string]*vpkgs.Pkg)
+    graph.status = make(map[string]Status)
+    graph.hashes = make(map[string]string)
+    graph.pulledBy = make(map[string]string)
+    graph.host = make(map[string]bool)
+    graph.kinds = make(map[edge]string)
+    graph.reasons = make(map[string]string)
+    graph.force = make(map[string]bool)
+    graph.noarchDone = make(map[string]bool)
+    graph.progress = os.Stdout
+    return graph
+}
+
+// List every vertex in the graph, parents before their children
+func (graphS Graph) vertices() []*dag.Vertex {
+    var vertices []*dag.Vertex
+    seen := make(map[string]bool)
+
+    var walk func(vertex *dag.Vertex)
+    walk = func(vertex *dag.Vertex) {
+        if seen[vertex.ID] {
+            return
+        }
+        seen[vertex.ID] = true
+        vertices = append(vertices, vertex)
+        children, _ := graphS.g.Successors(vertex)
+        for _, child := range children {
+            walk(child)
+        }
+    }
+    for _, vertex := range graphS.g.SourceVertices() {
+        walk(vertex)
+    }
+
+    return vertices
 }
 
 var pkgGraphError = errors.New("Package already exists in graph")
 var pkgRepoError = errors.New("Package is ready in repo")
+var pkgUnbuildableError = errors.New("Package cannot be built")
 
 // Add a package to the graph
 func (graphS Graph) addPkg(ident string, cfg cfg.Cfgs) error {
@@ -37,13 +104,13 @@ func (graphS Graph) addPkg(ident string, cfg cfg.Cfgs) error {
     // Run dbulk-dump
     dump, err := vpkgs.DbulkDump(ident, cfg)
     if err != nil {
-        return fmt.Errorf("%w adding ident to graph", err, ident)
+        return fmt.Errorf("%w adding %s to graph", err, ident)
     }
     // Add the dump to pkgs map under identifier
     graphS.pkgs[ident] = &dump
 
     // Check if package is already ready
-    if graphS.pkgs[ident].Ready {
+    if graphS.pkgs[ident].Ready && !graphS.force[ident] {
         return pkgRepoError
     }
 
@@ -52,154 +119,371 @@ func (graphS Graph) addPkg(ident string, cfg cfg.Cfgs) error {
     if err != nil {
         return fmt.Errorf("Error %w adding vertex %s", err, ident)
     }
+    graphS.status[ident] = StatusPending
+
+    // It is still added to the graph if it can't be built, so that we know
+    // what else can't be built because of it
+    arch := str.Split(ident, "@")[1]
+    buildable, reason := vpkgs.Buildable(dump, arch, cfg)
+    if !buildable {
+        fmt.Fprintf(os.Stderr, "WARN: %s cannot be built (%s).\n", ident, reason)
+        graphS.status[ident] = StatusUnbuildable
+        graphS.reasons[ident] = reason
+        return pkgUnbuildableError
+    }
 
     return nil
 }
 
-// Build dependencies of a package into the graph (recursively)
-func (graphS Graph) buildDeps(baseIdent string, cfg cfg.Cfgs) error {
+// Add a dependency of a package to the graph, along with its own
+// dependencies (recursively)
+func (graphS Graph) addDep(baseVertex *dag.Vertex, depIdent string, kind string, host bool, cfg cfg.Cfgs) error {
     graph := graphS.g
+    baseIdent := baseVertex.ID
 
-    var err error
+    // It may already have been added, e.g. as a depend of a noarch package
+    if _, exists := graphS.kinds[edge{baseIdent, depIdent}]; exists {
+        return nil
+    }
 
-    baseVertex, err := graph.GetVertex(baseIdent)
+    addPkgErr := graphS.addPkg(depIdent, cfg)
+    if addPkgErr != nil && !errors.Is(addPkgErr, pkgGraphError) && !errors.Is(addPkgErr, pkgRepoError) &&
+        !errors.Is(addPkgErr, pkgUnbuildableError) {
+        return addPkgErr
+    }
+    // If the package is already in the repo, we DON'T want to add a
+    // dep to it.
+    if errors.Is(addPkgErr, pkgRepoError) {
+        return nil
+    }
+    if addPkgErr == nil || errors.Is(addPkgErr, pkgUnbuildableError) {
+        graphS.pulledBy[depIdent] = baseIdent
+        if host {
+            graphS.host[depIdent] = true
+        }
+    }
+
+    // Add the edge; cycles are looked for once everything is graphed
+    depVertex, err := graph.GetVertex(depIdent)
     if err != nil {
-        return fmt.Errorf("Error %w getting vertex %s", err, baseIdent)
+        return fmt.Errorf("Error %w fetching vertex %s", err, depIdent)
     }
-    pkg := graphS.pkgs[baseIdent]
+    err = graph.AddEdge(baseVertex, depVertex)
+    if err != nil {
+        return fmt.Errorf("Error %w adding edge for %s -> %s", err, baseIdent, depIdent)
+    }
+    graphS.kinds[edge{baseIdent, depIdent}] = kind
 
-    // Resolve all subpackages + concat arays appropriatley
-    // If we are cross-building (host != target) then we need hostdepends
-    // and depends. If we are native building (host == target) then we
-    // only should have depends to avoid duplicates.
-    var hostdepends []string
-    var depends []string
-    arch := str.Split(baseIdent, "@")[1]
-    if cfg.HostArch != arch {
-        hostdepends, err = vpkgs.ResolveSubpackages(pkg.Hostmakedepends, cfg.HostArch, cfg)
+    // Recursively build dependencies
+    // Don't build it's deps if it already exists in the graph - no need
+    // to repeat that work. Nor if it can't be built anyway.
+    if addPkgErr == nil {
+        err = graphS.buildDeps(depIdent, cfg)
         if err != nil {
             return err
         }
     }
-    if cfg.HostArch != arch {
-        depends, err = vpkgs.ResolveSubpackages(append(pkg.Makedepends, pkg.Depends...), arch, cfg)
-    } else {
-        depends, err = vpkgs.ResolveSubpackages(append(pkg.Hostmakedepends, append(pkg.Makedepends, pkg.Depends...)...), arch, cfg)
+
+    return nil
+}
+
+// Work out which package and arch satisfy a dependency needed for an arch
+// foo-32bit is generated by building foo for i686, and noarch packages are
+// only ever built for the host. The arch the dependency is needed for (before
+// noarch packages are moved to the host) is also returned.
+func resolveDep(dep *vpkgs.Dep, arch string, cfg cfg.Cfgs) (string, string, string, error) {
+    if vpkgs.IsMultilib(dep.Name, arch, cfg) {
+        name := str.TrimSuffix(dep.Name, "-32bit")
+        // The constraint is checked against the i686 package too
+        if str.HasPrefix(dep.Constraint, dep.Name) {
+            dep.Constraint = name + str.TrimPrefix(dep.Constraint, dep.Name)
+        }
+        dep.Name = name
+        arch = "i686"
     }
+    targetArch := arch
+
+    // Resolve subpackages
+    depName, err := vpkgs.ResolveSubpackage(dep.Name + "@" + arch, cfg)
+    if err != nil {
+        return "", "", "", err
+    }
+
+    noarch, err := vpkgs.IsNoarch(depName, cfg)
+    if err != nil {
+        return "", "", "", err
+    }
+    if noarch {
+        arch = cfg.HostArch
+    }
+    return depName, arch, targetArch, nil
+}
+
+// Share a noarch package that is ready for the host with arch
+// It is only shared after being built in this run, so one built for the host
+// in an earlier run may be missing from the repository of arch.
+func (graphS Graph) shareReadyNoarch(ident string, arch string, cfg cfg.Cfgs) error {
+    pkg := graphS.pkgs[ident]
+    if arch == cfg.HostArch || !pkg.Noarch || !pkg.Ready || graphS.force[ident] {
+        return nil
+    }
+    pkgName := str.Split(ident, "@")[0]
+    ready, err := vpkgs.PkgReady(pkgName + "@" + arch, cfg)
     if err != nil {
         return err
     }
+    if ready {
+        return nil
+    }
+    fmt.Fprintf(graphS.progress, "Sharing %s with %s...\n", ident, arch)
+    return vpkgs.RegisterNoarch(pkgName, *pkg, arch, cfg)
+}
+
+// Graph the depends of a noarch package for the target arch it is needed for
+// The package itself is only built for the host, but installing it for arch
+// needs its depends built for arch. They hang off the noarch vertex, or off
+// requester if the noarch package is already in the repository.
+func (graphS Graph) noarchDepends(noarchIdent string, requester *dag.Vertex, arch string, cfg cfg.Cfgs) error {
+    if arch == cfg.HostArch || !graphS.pkgs[noarchIdent].Noarch {
+        return nil
+    }
+    err := graphS.shareReadyNoarch(noarchIdent, arch, cfg)
+    if err != nil {
+        return err
+    }
+    vertex, err := graphS.g.GetVertex(noarchIdent)
+    if err != nil {
+        if requester == nil {
+            return nil
+        }
+        vertex = requester
+    }
+    if graphS.status[vertex.ID] == StatusUnbuildable {
+        return nil
+    }
+    // Only once for each vertex, noarch package and arch
+    key := vertex.ID + " " + noarchIdent + " " + arch
+    if graphS.noarchDone[key] {
+        return nil
+    }
+    graphS.noarchDone[key] = true
 
-    // Start with hostdepends - where we are looking at depName@hostArch
-    // Make clear note! We are now in hostArch land! We are *NOT* building
-    // ANYTHING for the target arch! This is why arch must be hostArch
-    // for all invocations in this block.
-    for _, depName := range hostdepends {
-        depIdent := depName + "@" + cfg.HostArch
-        addPkgErr := graphS.addPkg(depIdent, cfg)
-        if addPkgErr != nil && !errors.Is(addPkgErr, pkgGraphError) && !errors.Is(addPkgErr, pkgRepoError) {
+    noarchName := str.Split(noarchIdent, "@")[0]
+    deps, err := vpkgs.ParseDeps(graphS.pkgs[noarchIdent].Depends, cfg)
+    if err != nil {
+        return fmt.Errorf("%w in depends of %s", err, noarchIdent)
+    }
+    for _, dep := range deps {
+        depName, depArch, targetArch, err := resolveDep(&dep, arch, cfg)
+        if err != nil {
             return err
         }
-        // If the package is already in the repo, we DON'T want to add a
-        // dep to it.
-        if errors.Is(addPkgErr, pkgRepoError) {
-            // Skip to next package
+        depIdent := depName + "@" + depArch
+        if depIdent == vertex.ID {
             continue
         }
-        // Add the edge
-        depVertex, err := graph.GetVertex(depIdent)
-        if err != nil {
-            return fmt.Errorf("Error %w fetching vertex %s", err, depIdent)
-        }
-        err = graph.AddEdge(baseVertex, depVertex)
-        if err != nil {
-            return fmt.Errorf("Error %w adding edge for %s -> %s", err, baseIdent, depIdent)
+        if _, exists := graphS.kinds[edge{vertex.ID, depIdent}]; exists {
+            continue
         }
-        // Recursively build dependencies
-        // Don't build it's deps if it already exists in the graph - no need
-        // to repeat that work.
-        if !errors.Is(addPkgErr, pkgGraphError) {
-            err = graphS.buildDeps(depIdent, cfg)
-            if err != nil {
-                return err
-            }
+        if cfg.IgnoredEdge(noarchName, depName) {
+            fmt.Fprintf(graphS.progress, "Ignoring dependency %s -> %s...\n", noarchIdent, depIdent)
+            continue
         }
-    }
 
-    // Now, depends (makedepends + depends) - these are handled with
-    // depName@arch.
-    for _, depName := range depends {
-        depIdent := depName + "@" + arch
-        addPkgErr := graphS.addPkg(depIdent, cfg)
-        if addPkgErr != nil && !errors.Is(addPkgErr, pkgGraphError) && !errors.Is(addPkgErr, pkgRepoError) {
+        err = graphS.addDep(vertex, depIdent, "depends", false, cfg)
+        if err != nil {
             return err
         }
-        // Don't add dep to existing package
-        if errors.Is(addPkgErr, pkgRepoError) {
-            // Skip to next
-            continue
-        }
-        // Add edge
-        depVertex, err := graph.GetVertex(depIdent)
+        // A noarch package depending on another needs its depends too
+        err = graphS.noarchDepends(depIdent, vertex, targetArch, cfg)
         if err != nil {
-            return fmt.Errorf("Error %w fetching vertex %s", err, depIdent)
+            return err
         }
-        err = graph.AddEdge(baseVertex, depVertex)
+    }
+    return nil
+}
+
+// Build dependencies of a package into the graph (recursively)
+func (graphS Graph) buildDeps(baseIdent string, cfg cfg.Cfgs) error {
+    graph := graphS.g
+
+    var err error
+
+    baseVertex, err := graph.GetVertex(baseIdent)
+    if err != nil {
+        return fmt.Errorf("Error %w getting vertex %s", err, baseIdent)
+    }
+    pkg := graphS.pkgs[baseIdent]
+    pkgName := str.Split(baseIdent, "@")[0]
+    arch := str.Split(baseIdent, "@")[1]
+
+    // If we are cross-building (host != target) then hostmakedepends are
+    // built for the host. If we are native building (host == target) then
+    // everything is built for the one arch, so a package listed under
+    // several kinds only gets one edge.
+    lists := []struct {
+        kind string
+        depNames []string
+    }{
+        {"hostmakedepends", pkg.Hostmakedepends},
+        {"makedepends", pkg.Makedepends},
+        {"depends", pkg.Depends},
+    }
+    seen := make(map[string]bool)
+    for _, list := range lists {
+        host := list.kind == "hostmakedepends" && cfg.HostArch != arch
+        depArch := arch
+        if host {
+            depArch = cfg.HostArch
+        }
+
+        // Strip version constraints and resolve virtual packages
+        deps, err := vpkgs.ParseDeps(list.depNames, cfg)
         if err != nil {
-            return fmt.Errorf("Error %w adding edge for %s -> %s", err, baseIdent, depIdent)
+            return fmt.Errorf("%w in %s of %s", err, list.kind, baseIdent)
         }
-        // Recurse
-        if !errors.Is(addPkgErr, pkgGraphError) {
-            err = graphS.buildDeps(depIdent, cfg)
+
+        for _, dep := range deps {
+            depName, depArch, targetArch, err := resolveDep(&dep, depArch, cfg)
+            if err != nil {
+                return err
+            }
+
+            // Make clear note! For host dependencies we are in hostArch
+            // land! We are *NOT* building ANYTHING for the target arch!
+            depIdent := depName + "@" + depArch
+            if seen[depIdent] {
+                continue
+            }
+            seen[depIdent] = true
+
+            // Edges may be ignored in configuration (e.g. to break cycles)
+            if cfg.IgnoredEdge(pkgName, depName) {
+                fmt.Fprintf(graphS.progress, "Ignoring dependency %s -> %s...\n", baseIdent, depIdent)
+                continue
+            }
+
+            err = graphS.addDep(baseVertex, depIdent, list.kind, host, cfg)
+            if err != nil {
+                return err
+            }
+            err = graphS.noarchDepends(depIdent, baseVertex, targetArch, cfg)
             if err != nil {
                 return err
             }
+
+            // Building something already in the repository won't help if it
+            // doesn't satisfy the constraint, so let the user know
+            if graphS.pkgs[depIdent].Ready {
+                satisfied, err := vpkgs.Satisfied(dep, depName, depArch, cfg)
+                if err != nil {
+                    return err
+                }
+                if !satisfied {
+                    fmt.Fprintf(os.Stderr, "WARN: %s in the repository does not satisfy %s (needed by %s).\n", depIdent, dep.Constraint, baseIdent)
+                }
+            }
         }
     }
 
     return nil
 }
 
+// Add a requested package (and its dependencies) to the graph
+func (graphS Graph) addRoot(pkgName string, arch string, cfg cfg.Cfgs) error {
+    // noarch packages are only ever built for the host
+    targetArch := arch
+    noarch, err := vpkgs.IsNoarch(pkgName, cfg)
+    if err != nil {
+        return err
+    }
+    if noarch {
+        if graphS.force[pkgName + "@" + arch] {
+            graphS.force[pkgName + "@" + cfg.HostArch] = true
+        }
+        arch = cfg.HostArch
+    }
+    ident := pkgName + "@" + arch
+
+    fmt.Fprintf(graphS.progress, "Graphing %s...\n", ident)
+    err = graphS.addPkg(ident, cfg)
+    if errors.Is(err, pkgRepoError) {
+        // Skip ready packages, once they are in the repository of arch
+        return graphS.shareReadyNoarch(ident, targetArch, cfg)
+    } else if errors.Is(err, pkgUnbuildableError) {
+        // Skip unbuildable packages
+        return nil
+    } else if errors.Is(err, pkgGraphError) {
+        // Already graphed, but maybe not for this target arch
+        return graphS.noarchDepends(ident, nil, targetArch, cfg)
+    } else if err != nil {
+        return err
+    }
+    err = graphS.buildDeps(ident, cfg)
+    if err != nil {
+        return err
+    }
+    return graphS.noarchDepends(ident, nil, targetArch, cfg)
+}
+
 // Generate the graph
-func Generate(pkgNames []string, cfg cfg.Cfgs) (Graph, error) {
+// pkgNames and force give the packages to build for each target arch.
+// Packages in force are rebuilt even if they are ready in the repository;
+// they must already be base packages. The arches share one graph, so
+// anything built for the host only appears once. What is being graphed is
+// written to progress.
+func Generate(pkgNames map[string][]string, force map[string][]string, progress io.Writer, cfg cfg.Cfgs) (Graph, error) {
     var err error
 
     // Create the DAG + map of pkg dumps
-    graph := Graph{g: dag.NewDAG()}
-    graph.pkgs = make(map[string]*vpkgs.Pkg)
-
-    // Create the masterdir to be used for all graphing operations
-    err = vpkgs.CreateMasterdir(cfg.MountDefault, cfg)
-    if err != nil {
-        return graph, err
+    graph := newGraph()
+    graph.progress = progress
+    for arch, archForce := range force {
+        for _, pkgName := range archForce {
+            graph.force[pkgName + "@" + arch] = true
+        }
     }
 
-    // Add the initial packages
-    // First, resolve the subpackages
-    pkgNames, err = vpkgs.ResolveSubpackages(pkgNames, cfg.Arch, cfg)
-    for _, pkgName := range pkgNames {
-        fmt.Printf("Graphing %s@%s...\n", pkgName, cfg.Arch)
-        err = graph.addPkg(pkgName + "@" + cfg.Arch, cfg)
-        if errors.Is(err, pkgGraphError) || errors.Is(err, pkgRepoError) {
-            // Skip existing packages
-            continue
-        } else if err != nil {
-            // Attempt to remove masterdir
-            vpkgs.RemoveMasterdir(cfg)
-            return graph, err
+    // The masterdir used for all graphing operations is only created once
+    // something is not in the dbulk-dump cache
+
+    // Add the initial packages of each arch
+    for _, arch := range cfg.Arches {
+        // First, resolve the subpackages
+        var archPkgNames []string
+        archPkgNames, err = vpkgs.ResolveSubpackages(pkgNames[arch], arch, cfg)
+        if err != nil {
+            break
+        }
+        archPkgNames = append(archPkgNames, force[arch]...)
+        for _, pkgName := range archPkgNames {
+            err = graph.addRoot(pkgName, arch, cfg)
+            if err != nil {
+                break
+            }
         }
-        err = graph.buildDeps(pkgName + "@" + cfg.Arch, cfg)
         if err != nil {
-            // Attempt to remove masterdir
+            break
+        }
+    }
+    // Nothing in a dependency cycle can ever be built
+    if err == nil {
+        err = graph.findCycle()
+    }
+    if err != nil {
+        // Attempt to remove masterdir
+        if vpkgs.MasterdirExists(cfg) {
             vpkgs.RemoveMasterdir(cfg)
-            return graph, err
         }
+        return graph, err
     }
 
     // Destroy masterdir used
-    err = vpkgs.RemoveMasterdir(cfg)
-    if err != nil {
-        return graph, err
+    if vpkgs.MasterdirExists(cfg) {
+        err = vpkgs.RemoveMasterdir(cfg)
+        if err != nil {
+            return graph, err
+        }
     }
 
     return graph, nil
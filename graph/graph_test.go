@@ -0,0 +1,181 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package graph
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "github.com/fosslinux/vxb/vpkgs"
+    "io/ioutil"
+    "os"
+    "reflect"
+    str "strings"
+    "testing"
+)
+
+// A package in a stub void-packages
+type testPkg struct {
+    name string
+    template string
+    depends []string
+    // Arches whose repository it is up to date in
+    ready []string
+}
+
+// Create a void-packages with a stub xbps-src, which gives the dbulk-dump of
+// each package, and put a stub xbps-checkvers first in PATH, along with a stub
+// xbps-rindex recording what it indexes in the indexed file
+// Packages are all version 1_1 and built for aarch64 on x86_64.
+func testTree(t *testing.T, pkgs []testPkg) cfg.Cfgs {
+    vpkgPath := t.TempDir()
+    write := func(fname string, data string, perm os.FileMode) {
+        err := ioutil.WriteFile(fname, []byte(data), perm)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    ready := make(map[string]string)
+    for _, pkg := range pkgs {
+        writeTemplate(t, vpkgPath, pkg.name, pkg.template)
+        dump := pkg.name + "\nversion: 1\nrevision: 1\n"
+        if len(pkg.depends) != 0 {
+            dump += "depends:\n " + str.Join(pkg.depends, "\n ") + "\n"
+        }
+        err := os.MkdirAll(vpkgPath + "/dumps", 0755)
+        if err != nil {
+            t.Fatal(err)
+        }
+        write(vpkgPath + "/dumps/" + pkg.name, dump, 0644)
+
+        for _, arch := range []string{"x86_64", "aarch64"} {
+            version := "?"
+            for _, readyArch := range pkg.ready {
+                if readyArch == arch {
+                    version = "1_1"
+                }
+            }
+            ready[arch] += pkg.name + " " + version + "\n"
+        }
+    }
+    for arch, vers := range ready {
+        write(vpkgPath + "/checkvers-" + arch, vers, 0644)
+    }
+    write(vpkgPath + "/xbps-src", "#!/bin/sh\nwhile [ \"$1\" != dbulk-dump ]; do shift; done\n" +
+        "cat \"dumps/$2\"\n", 0755)
+    err := os.MkdirAll(vpkgPath + "/masterdir/usr", 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Only -s lists every package; nothing is outdated
+    bin := t.TempDir()
+    write(bin + "/xbps-checkvers", "#!/bin/sh\nfor arg; do [ \"$arg\" = -s ] && " +
+        "cat '" + vpkgPath + "'/checkvers-$XBPS_TARGET_ARCH; done\nexit 0\n", 0755)
+    write(bin + "/xbps-rindex", "#!/bin/sh\necho $XBPS_TARGET_ARCH \"$@\" >> '" + vpkgPath +
+        "/indexed'\n", 0755)
+    path := os.Getenv("PATH")
+    os.Setenv("PATH", bin + ":" + path)
+    vpkgs.InvalidateAllReady()
+    t.Cleanup(func() {
+        os.Setenv("PATH", path)
+        vpkgs.InvalidateAllReady()
+    })
+
+    return cfg.Cfgs{VpkgPath: vpkgPath, Masterdir: "masterdir", HostArch: "x86_64",
+        Arches: []string{"aarch64"}}
+}
+
+func TestGenerateNoarchDependsFirst(t *testing.T) {
+    // L is reached through the noarch N before it is listed itself
+    cfg := testTree(t, []testPkg{
+        {"A", "", []string{"N", "L"}, nil},
+        {"N", "archs=noarch\n", []string{"L"}, []string{"x86_64", "aarch64"}},
+        {"L", "", nil, nil},
+    })
+    graphS, err := Generate(map[string][]string{"aarch64": {"A"}}, nil, ioutil.Discard, cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    children := testChildren(t, graphS, "A@aarch64")
+    if !reflect.DeepEqual(children, []string{"L@aarch64"}) {
+        t.Errorf("A@aarch64 depends on %v, want [L@aarch64]", children)
+    }
+    if kind := graphS.kinds[edge{"A@aarch64", "L@aarch64"}]; kind != "depends" {
+        t.Errorf("A@aarch64 -> L@aarch64 is %q, want depends", kind)
+    }
+}
+
+func TestGenerateSharesReadyNoarch(t *testing.T) {
+    tests := []struct {
+        name string
+        roots []string
+        // Arches N is up to date in
+        ready []string
+        shared bool
+    }{
+        {"requested", []string{"N"}, []string{"x86_64"}, true},
+        {"dependency", []string{"A"}, []string{"x86_64"}, true},
+        {"already shared", []string{"A"}, []string{"x86_64", "aarch64"}, false},
+        {"not built", []string{"A"}, nil, false},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            cfg := testTree(t, []testPkg{
+                {"A", "", []string{"N"}, nil},
+                {"N", "archs=noarch\n", nil, test.ready},
+            })
+            _, err := Generate(map[string][]string{"aarch64": test.roots}, nil, ioutil.Discard, cfg)
+            if err != nil {
+                t.Fatal(err)
+            }
+            data, err := ioutil.ReadFile(cfg.VpkgPath + "/indexed")
+            if err != nil && !os.IsNotExist(err) {
+                t.Fatal(err)
+            }
+            want := ""
+            if test.shared {
+                want = "aarch64 -a " + vpkgs.RepoPath("aarch64", cfg) + "/N-1_1.noarch.xbps\n"
+            }
+            if string(data[:]) != want {
+                t.Errorf("indexed %q, want %q", data, want)
+            }
+        })
+    }
+}
+
+func TestResolveDep(t *testing.T) {
+    cfg := testTree(t, []testPkg{{"foo", "", nil, nil}})
+    tests := []struct {
+        dep string
+        arch string
+        ident string
+        constraint string
+    }{
+        {"foo", "x86_64", "foo@x86_64", ""},
+        {"foo>=1.0", "x86_64", "foo@x86_64", "foo>=1.0"},
+        {"foo-32bit", "x86_64", "foo@i686", ""},
+        {"foo-32bit>=1.0", "x86_64", "foo@i686", "foo>=1.0"},
+        {"foo-32bit-1.0_1", "x86_64", "foo@i686", "foo-1.0_1"},
+    }
+    for _, test := range tests {
+        t.Run(test.dep, func(t *testing.T) {
+            deps, err := vpkgs.ParseDeps([]string{test.dep}, cfg)
+            if err != nil {
+                t.Fatal(err)
+            }
+            dep := deps[0]
+            depName, depArch, _, err := resolveDep(&dep, test.arch, cfg)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if ident := depName + "@" + depArch; ident != test.ident {
+                t.Errorf("resolved to %s, want %s", ident, test.ident)
+            }
+            if dep.Constraint != test.constraint {
+                t.Errorf("constraint is %q, want %q", dep.Constraint, test.constraint)
+            }
+        })
+    }
+}
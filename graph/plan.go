@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package graph
+
+import (
+    "github.com/goombaio/dag"
+    "encoding/json"
+    "fmt"
+    "io"
+    "sort"
+    str "strings"
+    "time"
+)
+
+// A single step of the build plan
+type PlanStep struct {
+    Pkgname string `json:"pkgname"`
+    Arch string `json:"arch"`
+    // Package that pulled this one in, empty if it was requested
+    PulledBy string `json:"pulled_by"`
+    // Built for the host as a dependency of a cross build
+    Host bool `json:"host"`
+}
+
+// A package that can't be built
+type PlanUnbuildable struct {
+    Pkgname string `json:"pkgname"`
+    Arch string `json:"arch"`
+    Reason string `json:"reason"`
+    // Requested packages that can't be built because of this one
+    Blocks []string `json:"blocks"`
+}
+
+// The build plan
+type Plan struct {
+    Steps []PlanStep `json:"steps"`
+    Unbuildable []PlanUnbuildable `json:"unbuildable"`
+}
+
+// Find the requested packages depending on a vertex (including itself)
+func (graphS Graph) requestedAbove(vertex *dag.Vertex, seen map[string]bool, requested *[]string) {
+    if seen[vertex.ID] {
+        return
+    }
+    seen[vertex.ID] = true
+    if _, pulled := graphS.pulledBy[vertex.ID]; !pulled {
+        *requested = append(*requested, vertex.ID)
+    }
+    parents, _ := graphS.g.Predecessors(vertex)
+    for _, parent := range parents {
+        graphS.requestedAbove(parent, seen, requested)
+    }
+}
+
+// Get the order packages would be built in by Build, and what can't be built
+// The order is the order packages are handed out in; with more than one job
+// they may finish in a different order. durations are past build durations,
+// as for Build.
+func (graphS Graph) Plan(durations map[string]time.Duration) (Plan, error) {
+    plan := Plan{Steps: []PlanStep{}, Unbuildable: []PlanUnbuildable{}}
+
+    // Pretend everything builds successfully
+    // Anything depending on an unbuildable package never becomes buildable
+    q := graphS.newQueue(durations)
+    for !q.empty() {
+        ident := q.pop()
+        splitIdent := str.Split(ident, "@")
+        plan.Steps = append(plan.Steps, PlanStep{
+            Pkgname: splitIdent[0],
+            Arch: splitIdent[1],
+            PulledBy: graphS.pulledBy[ident],
+            Host: graphS.host[ident],
+        })
+        err := q.built(ident)
+        if err != nil {
+            return plan, err
+        }
+    }
+
+    for _, ident := range graphS.withStatus(StatusUnbuildable) {
+        vertex, err := graphS.g.GetVertex(ident)
+        if err != nil {
+            return plan, fmt.Errorf("Error %w getting vertex %s", err, ident)
+        }
+        splitIdent := str.Split(ident, "@")
+        unbuildable := PlanUnbuildable{
+            Pkgname: splitIdent[0],
+            Arch: splitIdent[1],
+            Reason: graphS.reasons[ident],
+            Blocks: []string{},
+        }
+        graphS.requestedAbove(vertex, make(map[string]bool), &unbuildable.Blocks)
+        sort.Strings(unbuildable.Blocks)
+        plan.Unbuildable = append(plan.Unbuildable, unbuildable)
+    }
+
+    return plan, nil
+}
+
+// Print the build plan, either for humans or as JSON
+func (graphS Graph) PrintPlan(w io.Writer, asJSON bool, durations map[string]time.Duration) error {
+    plan, err := graphS.Plan(durations)
+    if err != nil {
+        return err
+    }
+
+    if asJSON {
+        enc := json.NewEncoder(w)
+        enc.SetIndent("", "  ")
+        err = enc.Encode(plan)
+        if err != nil {
+            return fmt.Errorf("Error %w encoding plan", err)
+        }
+        return nil
+    }
+
+    if len(plan.Steps) == 0 {
+        fmt.Fprintf(w, "Nothing to build.\n")
+    }
+    for i, step := range plan.Steps {
+        line := fmt.Sprintf("%d. %s@%s", i + 1, step.Pkgname, step.Arch)
+        if step.PulledBy == "" {
+            line += " (requested)"
+        } else {
+            line += fmt.Sprintf(" (pulled in by %s)", step.PulledBy)
+        }
+        if step.Host {
+            line += " [host]"
+        }
+        fmt.Fprintf(w, "%s\n", line)
+    }
+
+    if len(plan.Unbuildable) != 0 {
+        fmt.Fprintf(w, "Cannot be built:\n")
+    }
+    for _, unbuildable := range plan.Unbuildable {
+        fmt.Fprintf(w, "  %s@%s (%s)", unbuildable.Pkgname, unbuildable.Arch, unbuildable.Reason)
+        if len(unbuildable.Blocks) != 0 {
+            fmt.Fprintf(w, ", so neither can requested %s", str.Join(unbuildable.Blocks, ", "))
+        }
+        fmt.Fprintf(w, "\n")
+    }
+
+    return nil
+}
@@ -0,0 +1,210 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package graph
+
+import (
+    "github.com/goombaio/dag"
+    "fmt"
+    "time"
+)
+
+// How long a package with no history is guessed to take, if nothing has any
+const defaultEstimate = time.Minute
+
+// Queue of vertices that can be built
+// The vertex at the start of the longest chain of builds still to be done
+// (the critical path) is built first, using past build durations.
+type queue struct {
+    graphS Graph
+    // Number of children of each vertex that are still to be built
+    pending map[string]int
+    // Vertices that can be built now
+    ready []string
+    // How long each vertex is expected to take to build
+    estimates map[string]time.Duration
+    // Vertices with no past build duration
+    guessed map[string]bool
+    // Longest chain of builds starting at each vertex, see chains()
+    chain map[string]time.Duration
+}
+
+// Create a queue from the current state of the graph
+// durations are the past build durations of vertices, if known.
+func (graphS Graph) newQueue(durations map[string]time.Duration) *queue {
+    q := queue{graphS: graphS, pending: make(map[string]int)}
+    q.estimate(durations)
+
+    // Walk from the leaves up so that ready is in a stable order
+    var walk func(vertex *dag.Vertex)
+    walk = func(vertex *dag.Vertex) {
+        if _, seen := q.pending[vertex.ID]; seen {
+            return
+        }
+        q.pending[vertex.ID] = 0
+        children, _ := graphS.g.Successors(vertex)
+        for _, child := range children {
+            walk(child)
+            if !graphS.pkgs[child.ID].Ready {
+                q.pending[vertex.ID]++
+            }
+        }
+        if q.pending[vertex.ID] == 0 && graphS.status[vertex.ID] == StatusPending {
+            q.ready = append(q.ready, vertex.ID)
+        }
+    }
+    for _, vertex := range graphS.g.SourceVertices() {
+        walk(vertex)
+    }
+
+    q.chain = q.chains()
+    return &q
+}
+
+// Estimate how long each vertex takes to build
+// Vertices that have never been built are guessed to take the average.
+func (q *queue) estimate(durations map[string]time.Duration) {
+    q.estimates = make(map[string]time.Duration)
+    q.guessed = make(map[string]bool)
+    var total time.Duration
+    known := 0
+    for ident := range q.graphS.pkgs {
+        if duration, exists := durations[ident]; exists {
+            q.estimates[ident] = duration
+            total += duration
+            known++
+        }
+    }
+
+    guess := defaultEstimate
+    if known != 0 {
+        guess = total / time.Duration(known)
+    }
+    for ident := range q.graphS.pkgs {
+        if _, exists := q.estimates[ident]; !exists {
+            q.estimates[ident] = guess
+            q.guessed[ident] = true
+        }
+    }
+}
+
+// Work out the longest chain of builds still to be done starting at each
+// vertex, i.e. how long it and everything that (transitively) depends on it
+// take to build one after another
+func (q *queue) chains() map[string]time.Duration {
+    chain := make(map[string]time.Duration)
+    var walk func(vertex *dag.Vertex) time.Duration
+    walk = func(vertex *dag.Vertex) time.Duration {
+        if length, seen := chain[vertex.ID]; seen {
+            return length
+        }
+        if q.graphS.status[vertex.ID] != StatusPending {
+            chain[vertex.ID] = 0
+            return 0
+        }
+        var longest time.Duration
+        parents, _ := q.graphS.g.Predecessors(vertex)
+        for _, parent := range parents {
+            length := walk(parent)
+            if length > longest {
+                longest = length
+            }
+        }
+        chain[vertex.ID] = q.estimates[vertex.ID] + longest
+        return chain[vertex.ID]
+    }
+    for ident := range q.graphS.pkgs {
+        vertex, err := q.graphS.g.GetVertex(ident)
+        if err == nil {
+            walk(vertex)
+        }
+    }
+    return chain
+}
+
+// Estimate how long building what is left will take with jobs workers
+// It can't be quicker than the critical path, nor than sharing the work
+// perfectly between the workers.
+func (q *queue) eta(jobs int) time.Duration {
+    var critical, work time.Duration
+    for ident, length := range q.chains() {
+        if length > critical {
+            critical = length
+        }
+        if q.graphS.status[ident] == StatusPending {
+            work += q.estimates[ident]
+        }
+    }
+    if jobs < 1 {
+        jobs = 1
+    }
+    if work / time.Duration(jobs) > critical {
+        return work / time.Duration(jobs)
+    }
+    return critical
+}
+
+// Print how long building what is left should take
+func (q *queue) printETA(jobs int) {
+    left := q.graphS.withStatus(StatusPending)
+    if len(left) == 0 {
+        return
+    }
+    unknown := 0
+    for _, ident := range left {
+        if q.guessed[ident] {
+            unknown++
+        }
+    }
+    eta := q.eta(jobs).Round(time.Second)
+    if unknown == 0 {
+        fmt.Printf("%d package(s) left to build, about %s to go.\n", len(left), eta)
+    } else {
+        fmt.Printf("%d package(s) left to build, about %s to go (%d never built before).\n",
+            len(left), eta, unknown)
+    }
+}
+
+// Check if there is anything that can be built now
+func (q *queue) empty() bool {
+    return len(q.ready) == 0
+}
+
+// Take the next vertex to build, the one with the longest chain after it
+// Ties go to whatever became buildable first.
+func (q *queue) pop() string {
+    next := 0
+    for i, ident := range q.ready {
+        if q.chain[ident] > q.chain[q.ready[next]] {
+            next = i
+        }
+    }
+    ident := q.ready[next]
+    q.ready = append(q.ready[:next], q.ready[next + 1:]...)
+    return ident
+}
+
+// Forget everything that could be built
+func (q *queue) clear() {
+    q.ready = nil
+}
+
+// Record that a vertex has been built, queueing anything that is now buildable
+func (q *queue) built(ident string) error {
+    vertex, err := q.graphS.g.GetVertex(ident)
+    if err != nil {
+        return fmt.Errorf("Error %w getting vertex %s", err, ident)
+    }
+    parents, err := q.graphS.g.Predecessors(vertex)
+    if err != nil {
+        return fmt.Errorf("Unable to get parents of %s with %w", vertex.ID, err)
+    }
+    for _, parent := range parents {
+        q.pending[parent.ID]--
+        if q.pending[parent.ID] == 0 && q.graphS.status[parent.ID] == StatusPending {
+            q.ready = append(q.ready, parent.ID)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package graph
+
+import (
+    str "strings"
+    "testing"
+    "time"
+)
+
+// Mark vertices of a test graph as already built
+func testBuilt(graphS Graph, idents []string) {
+    for _, ident := range idents {
+        graphS.status[ident] = StatusBuilt
+        graphS.pkgs[ident].Ready = true
+    }
+}
+
+func TestQueueOrder(t *testing.T) {
+    idents := []string{"a@x86_64", "b@x86_64", "c@x86_64", "d@x86_64"}
+    tests := []struct {
+        name string
+        // From package to dependency
+        edges [][2]string
+        durations map[string]time.Duration
+        built []string
+        want string
+    }{
+        {"only dependencies first", [][2]string{{"a@x86_64", "b@x86_64"}, {"b@x86_64", "c@x86_64"},
+            {"c@x86_64", "d@x86_64"}}, nil, nil, "d@x86_64 c@x86_64 b@x86_64 a@x86_64"},
+        // b alone is quicker than d, but a waits for it
+        {"longest chain first", [][2]string{{"a@x86_64", "b@x86_64"}, {"c@x86_64", "d@x86_64"}},
+            map[string]time.Duration{"a@x86_64": 10 * time.Minute, "b@x86_64": time.Minute,
+                "c@x86_64": 2 * time.Minute, "d@x86_64": 5 * time.Minute},
+            nil, "b@x86_64 a@x86_64 d@x86_64 c@x86_64"},
+        // a is guessed at the average of 3m, making b's chain 5m
+        {"never built guessed at the average", [][2]string{{"a@x86_64", "b@x86_64"}},
+            map[string]time.Duration{"b@x86_64": 2 * time.Minute, "c@x86_64": 4 * time.Minute,
+                "d@x86_64": 3 * time.Minute},
+            []string{"d@x86_64"}, "b@x86_64 c@x86_64 a@x86_64"},
+        {"built not built again", [][2]string{{"a@x86_64", "b@x86_64"}, {"b@x86_64", "c@x86_64"}},
+            nil, []string{"c@x86_64", "d@x86_64"}, "b@x86_64 a@x86_64"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            graphS := testGraph(t, idents, test.edges)
+            testBuilt(graphS, test.built)
+
+            q := graphS.newQueue(test.durations)
+            var order []string
+            for !q.empty() {
+                ident := q.pop()
+                order = append(order, ident)
+                testBuilt(graphS, []string{ident})
+                err := q.built(ident)
+                if err != nil {
+                    t.Fatal(err)
+                }
+            }
+            if got := str.Join(order, " "); got != test.want {
+                t.Errorf("order is %q, want %q", got, test.want)
+            }
+        })
+    }
+}
+
+func TestQueueETA(t *testing.T) {
+    idents := []string{"a@x86_64", "b@x86_64", "c@x86_64"}
+    // a (10m) depends on b (1m), c (5m) stands alone
+    edges := [][2]string{{"a@x86_64", "b@x86_64"}}
+    durations := map[string]time.Duration{"a@x86_64": 10 * time.Minute, "b@x86_64": time.Minute,
+        "c@x86_64": 5 * time.Minute}
+    tests := []struct {
+        name string
+        jobs int
+        built []string
+        want time.Duration
+    }{
+        {"one job does all the work", 1, nil, 16 * time.Minute},
+        {"no jobs is one job", 0, nil, 16 * time.Minute},
+        {"two jobs wait for the critical path", 2, nil, 11 * time.Minute},
+        {"more jobs than work", 8, nil, 11 * time.Minute},
+        {"built work is done", 1, []string{"b@x86_64"}, 15 * time.Minute},
+        {"built shortens the critical path", 2, []string{"b@x86_64"}, 10 * time.Minute},
+        {"everything built", 1, idents, 0},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            graphS := testGraph(t, idents, edges)
+            testBuilt(graphS, test.built)
+            q := graphS.newQueue(durations)
+            if got := q.eta(test.jobs); got != test.want {
+                t.Errorf("ETA is %s, want %s", got, test.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,230 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package graph
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "github.com/fosslinux/vxb/vpkgs"
+    "github.com/goombaio/dag"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    str "strings"
+)
+
+// A vertex as written to the state file
+type stateVertex struct {
+    Ident string
+    Pkg vpkgs.Pkg
+    Status Status
+    // Hash of the template when the graph was generated
+    Hash string
+    PulledBy string
+    Host bool
+    // Why it can't be built, if it can't
+    Reason string
+    Force bool
+    Children []string
+}
+
+// The state file
+type state struct {
+    Vertices []stateVertex
+}
+
+// Hash the templates of every vertex that does not have a hash yet
+func (graphS Graph) hashTemplates(cfg cfg.Cfgs) error {
+    for ident := range graphS.status {
+        if _, exists := graphS.hashes[ident]; exists {
+            continue
+        }
+        hash, err := vpkgs.TemplateHash(str.Split(ident, "@")[0], cfg)
+        if err != nil {
+            return err
+        }
+        graphS.hashes[ident] = hash
+    }
+    return nil
+}
+
+// Save the graph and the build status of each vertex to a file
+func (graphS Graph) Save(fname string, cfg cfg.Cfgs) error {
+    var err error
+
+    err = graphS.hashTemplates(cfg)
+    if err != nil {
+        return err
+    }
+
+    st := state{}
+    for _, vertex := range graphS.vertices() {
+        children, err := graphS.g.Successors(vertex)
+        if err != nil {
+            return fmt.Errorf("Unable to get children of %s with %w", vertex.ID, err)
+        }
+        sv := stateVertex{
+            Ident: vertex.ID,
+            Pkg: *graphS.pkgs[vertex.ID],
+            Status: graphS.status[vertex.ID],
+            Hash: graphS.hashes[vertex.ID],
+            PulledBy: graphS.pulledBy[vertex.ID],
+            Host: graphS.host[vertex.ID],
+            Reason: graphS.reasons[vertex.ID],
+            Force: graphS.force[vertex.ID],
+        }
+        for _, child := range children {
+            sv.Children = append(sv.Children, child.ID)
+        }
+        st.Vertices = append(st.Vertices, sv)
+    }
+
+    data, err := json.MarshalIndent(st, "", "  ")
+    if err != nil {
+        return fmt.Errorf("Error %w encoding state", err)
+    }
+
+    // Write to a temporary file first so we never leave a half-written state
+    f, err := os.Create(fname + ".tmp")
+    if err != nil {
+        return fmt.Errorf("Unable to create %s with %w", fname + ".tmp", err)
+    }
+    _, err = f.Write(data)
+    if err == nil {
+        err = f.Sync()
+    }
+    f.Close()
+    if err != nil {
+        return fmt.Errorf("Unable to write to %s with %w", fname + ".tmp", err)
+    }
+    err = os.Rename(fname + ".tmp", fname)
+    if err != nil {
+        return fmt.Errorf("Unable to rename %s to %s with %w", fname + ".tmp", fname, err)
+    }
+
+    // This has everything built so far
+    err = os.Remove(builtPath(fname))
+    if err != nil && !os.IsNotExist(err) {
+        return fmt.Errorf("Unable to remove %s with %w", builtPath(fname), err)
+    }
+
+    return nil
+}
+
+// Path of the list of vertices built since a state file was saved
+func builtPath(fname string) string {
+    return fname + ".built"
+}
+
+// Record that a vertex has been built, without saving the whole graph again
+// The vertex is added to a list next to the state file, which Load reads
+// too.
+func saveBuilt(fname string, ident string) error {
+    f, err := os.OpenFile(builtPath(fname), os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
+    if err != nil {
+        return fmt.Errorf("Unable to open %s with %w", builtPath(fname), err)
+    }
+    _, err = f.WriteString(ident + "\n")
+    f.Close()
+    if err != nil {
+        return fmt.Errorf("Unable to write to %s with %w", builtPath(fname), err)
+    }
+    return nil
+}
+
+// Read the vertices built since a state file was saved
+// A line cut off by an interruption is ignored.
+func loadBuilt(fname string) (map[string]bool, error) {
+    built := make(map[string]bool)
+    data, err := ioutil.ReadFile(builtPath(fname))
+    if os.IsNotExist(err) {
+        return built, nil
+    }
+    if err != nil {
+        return built, fmt.Errorf("Unable to read %s with %w", builtPath(fname), err)
+    }
+    lines := str.Split(string(data[:]), "\n")
+    // Everything before the last newline is complete
+    for _, ident := range lines[:len(lines) - 1] {
+        built[ident] = true
+    }
+    return built, nil
+}
+
+// Load a graph saved with Save, along with what was built after
+// Vertices that failed or were skipped are pending again so that they are
+// retried. Errors if any template has changed since the graph was saved.
+func Load(fname string, cfg cfg.Cfgs) (Graph, error) {
+    graph := newGraph()
+
+    data, err := ioutil.ReadFile(fname)
+    if err != nil {
+        return graph, fmt.Errorf("Unable to read %s with %w", fname, err)
+    }
+    st := state{}
+    err = json.Unmarshal(data, &st)
+    if err != nil {
+        return graph, fmt.Errorf("Error %w decoding %s", err, fname)
+    }
+    built, err := loadBuilt(fname)
+    if err != nil {
+        return graph, err
+    }
+
+    // Add all of the vertices first, then the edges between them
+    for _, sv := range st.Vertices {
+        hash, err := vpkgs.TemplateHash(str.Split(sv.Ident, "@")[0], cfg)
+        if err != nil {
+            return graph, err
+        }
+        if hash != sv.Hash {
+            return graph, fmt.Errorf("Template of %s has changed since %s was written", sv.Ident, fname)
+        }
+
+        pkg := sv.Pkg
+        graph.pkgs[sv.Ident] = &pkg
+        graph.hashes[sv.Ident] = sv.Hash
+        if sv.PulledBy != "" {
+            graph.pulledBy[sv.Ident] = sv.PulledBy
+        }
+        if sv.Host {
+            graph.host[sv.Ident] = true
+        }
+        if sv.Force {
+            graph.force[sv.Ident] = true
+        }
+        if sv.Status == StatusBuilt || built[sv.Ident] {
+            graph.status[sv.Ident] = StatusBuilt
+            pkg.Ready = true
+        } else if sv.Status == StatusUnbuildable {
+            graph.status[sv.Ident] = StatusUnbuildable
+            graph.reasons[sv.Ident] = sv.Reason
+        } else {
+            graph.status[sv.Ident] = StatusPending
+        }
+        err = graph.g.AddVertex(dag.NewVertex(sv.Ident, nil))
+        if err != nil {
+            return graph, fmt.Errorf("Error %w adding vertex %s", err, sv.Ident)
+        }
+    }
+    for _, sv := range st.Vertices {
+        vertex, err := graph.g.GetVertex(sv.Ident)
+        if err != nil {
+            return graph, fmt.Errorf("Error %w getting vertex %s", err, sv.Ident)
+        }
+        for _, childIdent := range sv.Children {
+            child, err := graph.g.GetVertex(childIdent)
+            if err != nil {
+                return graph, fmt.Errorf("Error %w fetching vertex %s", err, childIdent)
+            }
+            err = graph.g.AddEdge(vertex, child)
+            if err != nil {
+                return graph, fmt.Errorf("Error %w adding edge for %s -> %s", err, sv.Ident, childIdent)
+            }
+        }
+    }
+
+    return graph, nil
+}
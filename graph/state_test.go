@@ -0,0 +1,239 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package graph
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "github.com/fosslinux/vxb/vpkgs"
+    "github.com/goombaio/dag"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    str "strings"
+    "testing"
+)
+
+// Create a graph of pending vertices with edges from package to dependency
+func testGraph(t *testing.T, idents []string, edges [][2]string) Graph {
+    graphS := newGraph()
+    for _, ident := range idents {
+        err := graphS.g.AddVertex(dag.NewVertex(ident, nil))
+        if err != nil {
+            t.Fatal(err)
+        }
+        graphS.pkgs[ident] = &vpkgs.Pkg{}
+        graphS.status[ident] = StatusPending
+    }
+    for _, e := range edges {
+        from, err := graphS.g.GetVertex(e[0])
+        if err != nil {
+            t.Fatal(err)
+        }
+        to, err := graphS.g.GetVertex(e[1])
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = graphS.g.AddEdge(from, to)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    return graphS
+}
+
+// Create a void-packages with a template for each package
+func testVpkgs(t *testing.T, idents []string) cfg.Cfgs {
+    vpkgPath := t.TempDir()
+    for _, ident := range idents {
+        writeTemplate(t, vpkgPath, str.Split(ident, "@")[0], "version=1\n")
+    }
+    return cfg.Cfgs{VpkgPath: vpkgPath}
+}
+
+// Write the template of a package
+func writeTemplate(t *testing.T, vpkgPath string, pkgName string, template string) {
+    dir := vpkgPath + "/srcpkgs/" + pkgName
+    err := os.MkdirAll(dir, 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = ioutil.WriteFile(dir + "/template", []byte(template), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+}
+
+// The children of a vertex, sorted
+func testChildren(t *testing.T, graphS Graph, ident string) []string {
+    vertex, err := graphS.g.GetVertex(ident)
+    if err != nil {
+        t.Fatal(err)
+    }
+    children, err := graphS.g.Successors(vertex)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var idents []string
+    for _, child := range children {
+        idents = append(idents, child.ID)
+    }
+    sort.Strings(idents)
+    return idents
+}
+
+func TestSaveLoad(t *testing.T) {
+    idents := []string{"foo@x86_64", "bar@x86_64", "baz@x86_64", "qux@x86_64"}
+    edges := [][2]string{{"foo@x86_64", "bar@x86_64"}, {"foo@x86_64", "baz@x86_64"},
+        {"bar@x86_64", "qux@x86_64"}}
+
+    tests := []struct {
+        name string
+        status Status
+        // Status after loading
+        want Status
+    }{
+        {"built stays built", StatusBuilt, StatusBuilt},
+        {"pending stays pending", StatusPending, StatusPending},
+        {"failed is retried", StatusFailed, StatusPending},
+        {"skipped is retried", StatusSkipped, StatusPending},
+        {"timed out is retried", StatusTimeout, StatusPending},
+        {"unbuildable stays unbuildable", StatusUnbuildable, StatusUnbuildable},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            cfg := testVpkgs(t, idents)
+            graphS := testGraph(t, idents, edges)
+            graphS.status["bar@x86_64"] = test.status
+            graphS.reasons["bar@x86_64"] = "broken"
+            graphS.pkgs["bar@x86_64"].Version = "1.0_1"
+            graphS.pulledBy["bar@x86_64"] = "foo@x86_64"
+            graphS.host["bar@x86_64"] = true
+            graphS.force["baz@x86_64"] = true
+
+            fname := filepath.Join(t.TempDir(), "state.json")
+            err := graphS.Save(fname, cfg)
+            if err != nil {
+                t.Fatal(err)
+            }
+            loaded, err := Load(fname, cfg)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            if got := loaded.status["bar@x86_64"]; got != test.want {
+                t.Errorf("status of bar@x86_64 is %s, want %s", got, test.want)
+            }
+            if got := loaded.pkgs["bar@x86_64"].Ready; got != (test.want == StatusBuilt) {
+                t.Errorf("bar@x86_64 ready is %t", got)
+            }
+            if test.want == StatusUnbuildable && loaded.reasons["bar@x86_64"] != "broken" {
+                t.Errorf("reason of bar@x86_64 is %q", loaded.reasons["bar@x86_64"])
+            }
+            if got := loaded.pkgs["bar@x86_64"].Version; got != "1.0_1" {
+                t.Errorf("version of bar@x86_64 is %q", got)
+            }
+            if loaded.pulledBy["bar@x86_64"] != "foo@x86_64" || !loaded.host["bar@x86_64"] {
+                t.Errorf("bar@x86_64 lost where it came from")
+            }
+            if !loaded.force["baz@x86_64"] || loaded.force["foo@x86_64"] {
+                t.Errorf("forced vertices are %v", loaded.force)
+            }
+            for _, ident := range idents {
+                got := str.Join(testChildren(t, loaded, ident), " ")
+                want := str.Join(testChildren(t, graphS, ident), " ")
+                if got != want {
+                    t.Errorf("children of %s are %q, want %q", ident, got, want)
+                }
+            }
+        })
+    }
+}
+
+func TestLoadChangedTemplate(t *testing.T) {
+    idents := []string{"foo@x86_64", "bar@x86_64"}
+    cfg := testVpkgs(t, idents)
+    graphS := testGraph(t, idents, [][2]string{{"foo@x86_64", "bar@x86_64"}})
+
+    fname := filepath.Join(t.TempDir(), "state.json")
+    err := graphS.Save(fname, cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    writeTemplate(t, cfg.VpkgPath, "bar", "version=2\n")
+    _, err = Load(fname, cfg)
+    if err == nil || !str.Contains(err.Error(), "bar@x86_64") {
+        t.Errorf("loading after bar changed gave %v", err)
+    }
+}
+
+func TestLoadBuilt(t *testing.T) {
+    idents := []string{"foo@x86_64", "bar@x86_64", "baz@x86_64"}
+    cfg := testVpkgs(t, idents)
+    graphS := testGraph(t, idents, [][2]string{{"foo@x86_64", "bar@x86_64"}})
+
+    fname := filepath.Join(t.TempDir(), "state.json")
+    err := graphS.Save(fname, cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    before, err := ioutil.ReadFile(fname)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, ident := range []string{"bar@x86_64", "foo@x86_64"} {
+        err = saveBuilt(fname, ident)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    // Interrupted while recording baz
+    f, err := os.OpenFile(builtPath(fname), os.O_WRONLY | os.O_APPEND, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    f.WriteString("baz@x86")
+    f.Close()
+
+    // The state file itself is left alone
+    after, err := ioutil.ReadFile(fname)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(after[:]) != string(before[:]) {
+        t.Errorf("state file was rewritten")
+    }
+
+    loaded, err := Load(fname, cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := map[string]Status{"foo@x86_64": StatusBuilt, "bar@x86_64": StatusBuilt, "baz@x86_64": StatusPending}
+    for ident, status := range want {
+        if got := loaded.status[ident]; got != status {
+            t.Errorf("status of %s is %s, want %s", ident, got, status)
+        }
+        if got := loaded.pkgs[ident].Ready; got != (status == StatusBuilt) {
+            t.Errorf("%s ready is %t", ident, got)
+        }
+    }
+
+    // Saving the whole graph again starts a new list
+    err = loaded.Save(fname, cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = os.Stat(builtPath(fname))
+    if !os.IsNotExist(err) {
+        t.Errorf("%s was kept after saving", builtPath(fname))
+    }
+    reloaded, err := Load(fname, cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if reloaded.status["bar@x86_64"] != StatusBuilt || reloaded.status["baz@x86_64"] != StatusPending {
+        t.Errorf("statuses after saving again are %v", reloaded.status)
+    }
+}
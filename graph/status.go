@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package graph
+
+import (
+    "github.com/goombaio/dag"
+    "fmt"
+    "sort"
+    str "strings"
+)
+
+// Build status of a vertex
+type Status int
+
+const (
+    // Not built (yet)
+    StatusPending Status = iota
+    // Successfully built
+    StatusBuilt
+    // Failed to build
+    StatusFailed
+    // Not built because something it depends on failed
+    StatusSkipped
+    // Can never be built (broken, wrong arch, etc)
+    StatusUnbuildable
+    // Took longer than its timeout to build
+    StatusTimeout
+)
+
+// Human readable status
+func (status Status) String() string {
+    switch status {
+        case StatusBuilt:
+            return "built"
+        case StatusFailed:
+            return "failed"
+        case StatusSkipped:
+            return "skipped"
+        case StatusUnbuildable:
+            return "unbuildable"
+        case StatusTimeout:
+            return "timed out"
+    }
+    return "pending"
+}
+
+// Mark everything depending on a vertex (recursively) as skipped
+func (graphS Graph) skipParents(vertex *dag.Vertex) error {
+    parents, err := graphS.g.Predecessors(vertex)
+    if err != nil {
+        return fmt.Errorf("Unable to get parents of %s with %w", vertex.ID, err)
+    }
+    for _, parent := range parents {
+        if graphS.status[parent.ID] != StatusPending {
+            continue
+        }
+        graphS.status[parent.ID] = StatusSkipped
+        err = graphS.skipParents(parent)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Mark everything depending on an unbuildable vertex (recursively) as
+// unbuildable too
+func (graphS Graph) unbuildableParents(vertex *dag.Vertex) error {
+    parents, err := graphS.g.Predecessors(vertex)
+    if err != nil {
+        return fmt.Errorf("Unable to get parents of %s with %w", vertex.ID, err)
+    }
+    for _, parent := range parents {
+        if graphS.status[parent.ID] != StatusPending {
+            continue
+        }
+        graphS.status[parent.ID] = StatusUnbuildable
+        graphS.reasons[parent.ID] = "depends on " + vertex.ID
+        err = graphS.unbuildableParents(parent)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// List the vertices with a given status
+func (graphS Graph) withStatus(status Status) []string {
+    var idents []string
+    for ident, identStatus := range graphS.status {
+        if identStatus == status {
+            idents = append(idents, ident)
+        }
+    }
+    sort.Strings(idents)
+    return idents
+}
+
+// Count the vertices that failed, timed out or were skipped
+// Unbuildable vertices never could have been built, so aren't failures.
+func (graphS Graph) Failures() int {
+    return len(graphS.withStatus(StatusFailed)) + len(graphS.withStatus(StatusTimeout)) +
+        len(graphS.withStatus(StatusSkipped))
+}
+
+// Print a summary of what happened to each package in the graph, for each
+// arch
+func (graphS Graph) Summary() {
+    // Group by arch
+    var arches []string
+    byArch := make(map[string]map[Status][]string)
+    for _, status := range []Status{StatusBuilt, StatusFailed, StatusTimeout, StatusUnbuildable, StatusSkipped, StatusPending} {
+        for _, ident := range graphS.withStatus(status) {
+            arch := str.Split(ident, "@")[1]
+            if byArch[arch] == nil {
+                byArch[arch] = make(map[Status][]string)
+                arches = append(arches, arch)
+            }
+            byArch[arch][status] = append(byArch[arch][status], ident)
+        }
+    }
+    sort.Strings(arches)
+
+    for _, arch := range arches {
+        fmt.Printf("Summary for %s:\n", arch)
+        for _, status := range []Status{StatusBuilt, StatusFailed, StatusTimeout, StatusUnbuildable, StatusSkipped, StatusPending} {
+            idents := byArch[arch][status]
+            if len(idents) == 0 {
+                continue
+            }
+            fmt.Printf("  %s (%d):\n", status, len(idents))
+            for _, ident := range idents {
+                if reason, exists := graphS.reasons[ident]; exists {
+                    fmt.Printf("    %s (%s)\n", ident, reason)
+                } else {
+                    fmt.Printf("    %s\n", ident)
+                }
+            }
+        }
+    }
+}
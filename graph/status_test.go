@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package graph
+
+import (
+    "testing"
+)
+
+func TestFailures(t *testing.T) {
+    idents := []string{"a@x86_64", "b@x86_64", "c@x86_64", "d@x86_64", "e@x86_64"}
+    // a and b depend on c, which can't be built
+    graphS := testGraph(t, idents, [][2]string{{"a@x86_64", "b@x86_64"}, {"b@x86_64", "c@x86_64"},
+        {"e@x86_64", "d@x86_64"}})
+    graphS.status["c@x86_64"] = StatusUnbuildable
+    graphS.reasons["c@x86_64"] = "broken"
+    vertex, err := graphS.g.GetVertex("c@x86_64")
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = graphS.unbuildableParents(vertex)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for ident, reason := range map[string]string{"a@x86_64": "depends on b@x86_64", "b@x86_64": "depends on c@x86_64"} {
+        if graphS.status[ident] != StatusUnbuildable || graphS.reasons[ident] != reason {
+            t.Errorf("%s is %s (%s), want unbuildable (%s)", ident, graphS.status[ident], graphS.reasons[ident], reason)
+        }
+    }
+
+    // Nothing that could be built failed
+    graphS.status["d@x86_64"] = StatusBuilt
+    graphS.status["e@x86_64"] = StatusBuilt
+    if failures := graphS.Failures(); failures != 0 {
+        t.Errorf("%d failures with only unbuildable packages", failures)
+    }
+
+    // A failure skips what depends on it, and both count
+    graphS.status["d@x86_64"] = StatusFailed
+    graphS.status["e@x86_64"] = StatusSkipped
+    if failures := graphS.Failures(); failures != 2 {
+        t.Errorf("%d failures, want 2", failures)
+    }
+}
@@ -0,0 +1,177 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package history
+
+import (
+    bolt "go.etcd.io/bbolt"
+    "encoding/binary"
+    "encoding/json"
+    "fmt"
+    "os"
+    "sync"
+    "time"
+)
+
+// Bucket holding every build attempt, keyed by start time
+var attemptsBucket = []byte("attempts")
+
+// Outcome of a build attempt
+const (
+    StatusSuccess = "success"
+    StatusFailed = "failed"
+    StatusTimeout = "timeout"
+)
+
+// A single attempt at building a package
+type Record struct {
+    Pkgname string
+    Arch string
+    Version string
+    // Commit of void-packages, if it is a git checkout
+    Commit string
+    Started time.Time
+    Duration time.Duration
+    // StatusSuccess, StatusFailed or StatusTimeout
+    Status string
+    // Exit status of xbps-src, or the error if it didn't get that far
+    ExitStatus string
+    MountType string
+    // Empty if there is no log file
+    LogPath string
+}
+
+// What to find in the history
+// Empty fields match everything.
+type Query struct {
+    Pkgname string
+    Arch string
+    Status string
+    Since time.Time
+    Until time.Time
+}
+
+// The build history database
+// The database is locked while it is open, so it is only opened for as long
+// as each read or write takes. This lets vxb history and other vxbs use it
+// while a build is running.
+type DB struct {
+    path string
+    // Only one of our writes opens the database at a time
+    mutex sync.Mutex
+}
+
+// Use the history database at path (created when first written to)
+func Open(path string) *DB {
+    return &DB{path: path}
+}
+
+// Open the database file
+func (hist *DB) open(readOnly bool) (*bolt.DB, error) {
+    db, err := bolt.Open(hist.path, 0644, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: readOnly})
+    if err != nil {
+        return nil, fmt.Errorf("Error %w opening history %s", err, hist.path)
+    }
+    return db, nil
+}
+
+// Record a build attempt
+func (hist *DB) Add(record Record) error {
+    value, err := json.Marshal(record)
+    if err != nil {
+        return fmt.Errorf("Error %w encoding history of %s@%s", err, record.Pkgname, record.Arch)
+    }
+
+    hist.mutex.Lock()
+    defer hist.mutex.Unlock()
+    db, err := hist.open(false)
+    if err != nil {
+        return err
+    }
+    defer db.Close()
+    return db.Update(func(tx *bolt.Tx) error {
+        bucket, err := tx.CreateBucketIfNotExists(attemptsBucket)
+        if err != nil {
+            return err
+        }
+        // Start time, then a sequence number in case of a tie
+        seq, err := bucket.NextSequence()
+        if err != nil {
+            return err
+        }
+        key := make([]byte, 16)
+        binary.BigEndian.PutUint64(key[:8], uint64(record.Started.UnixNano()))
+        binary.BigEndian.PutUint64(key[8:], seq)
+        return bucket.Put(key, value)
+    })
+}
+
+// Check if a record matches a query
+func (query Query) matches(record Record) bool {
+    return (query.Pkgname == "" || query.Pkgname == record.Pkgname) &&
+        (query.Arch == "" || query.Arch == record.Arch) &&
+        (query.Status == "" || query.Status == record.Status) &&
+        (query.Until.IsZero() || record.Started.Before(query.Until))
+}
+
+// Find the build attempts matching a query, oldest first
+// A database that doesn't exist yet has no attempts.
+func (hist *DB) Find(query Query) ([]Record, error) {
+    var records []Record
+    _, exists := os.Stat(hist.path)
+    if os.IsNotExist(exists) {
+        return records, nil
+    }
+    db, err := hist.open(true)
+    if err != nil {
+        return records, err
+    }
+    defer db.Close()
+
+    err = db.View(func(tx *bolt.Tx) error {
+        bucket := tx.Bucket(attemptsBucket)
+        if bucket == nil {
+            return nil
+        }
+        cursor := bucket.Cursor()
+
+        // Keys are ordered by start time, so skip straight to Since
+        var key, value []byte
+        if query.Since.IsZero() {
+            key, value = cursor.First()
+        } else {
+            seek := make([]byte, 8)
+            binary.BigEndian.PutUint64(seek, uint64(query.Since.UnixNano()))
+            key, value = cursor.Seek(seek)
+        }
+
+        for ; key != nil; key, value = cursor.Next() {
+            var record Record
+            err := json.Unmarshal(value, &record)
+            if err != nil {
+                return fmt.Errorf("Error %w decoding history", err)
+            }
+            if query.matches(record) {
+                records = append(records, record)
+            }
+        }
+        return nil
+    })
+    return records, err
+}
+
+// Get how long the last successful build of each package took
+// The map is keyed by <pkgname>@<arch>, like the graph.
+func (hist *DB) LastDurations() (map[string]time.Duration, error) {
+    durations := make(map[string]time.Duration)
+    records, err := hist.Find(Query{Status: StatusSuccess})
+    if err != nil {
+        return durations, err
+    }
+    // Oldest first, so later builds win
+    for _, record := range records {
+        durations[record.Pkgname + "@" + record.Arch] = record.Duration
+    }
+    return durations, nil
+}
@@ -0,0 +1,133 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package history
+
+import (
+    bolt "go.etcd.io/bbolt"
+    "path/filepath"
+    str "strings"
+    "testing"
+    "time"
+)
+
+func TestNotLockedBetweenUses(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "history.db")
+    hist := Open(path)
+
+    // Nothing is there until something is recorded
+    records, err := hist.Find(Query{})
+    if err != nil || len(records) != 0 {
+        t.Fatalf("empty history gave %v, %v", records, err)
+    }
+
+    err = hist.Add(Record{Pkgname: "foo", Arch: "x86_64", Started: time.Now(), Status: StatusSuccess})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Someone else can write while we are not
+    other, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 100 * time.Millisecond})
+    if err != nil {
+        t.Fatalf("history is still locked: %s", err)
+    }
+    other.Close()
+
+    // Several can read at once
+    reader, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 100 * time.Millisecond, ReadOnly: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer reader.Close()
+    records, err = Open(path).Find(Query{})
+    if err != nil {
+        t.Fatalf("reading alongside another reader gave %s", err)
+    }
+    if len(records) != 1 || records[0].Pkgname != "foo" {
+        t.Errorf("found %v", records)
+    }
+}
+
+func TestFind(t *testing.T) {
+    base := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+    hist := Open(filepath.Join(t.TempDir(), "history.db"))
+    // Added out of order, with two starting at the same time
+    for _, record := range []Record{
+        {Pkgname: "foo", Arch: "x86_64", Started: base.Add(2 * time.Hour), Status: StatusFailed},
+        {Pkgname: "foo", Arch: "x86_64", Started: base, Status: StatusSuccess},
+        {Pkgname: "bar", Arch: "aarch64", Started: base.Add(time.Hour), Status: StatusTimeout},
+        {Pkgname: "baz", Arch: "x86_64", Started: base.Add(time.Hour), Status: StatusSuccess},
+        {Pkgname: "foo", Arch: "aarch64", Started: base.Add(3 * time.Hour), Status: StatusSuccess},
+    } {
+        err := hist.Add(record)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    tests := []struct {
+        name string
+        query Query
+        // pkgname@arch of what is found, in order
+        want []string
+    }{
+        {"everything, oldest first", Query{},
+            []string{"foo@x86_64", "bar@aarch64", "baz@x86_64", "foo@x86_64", "foo@aarch64"}},
+        {"package", Query{Pkgname: "foo"}, []string{"foo@x86_64", "foo@x86_64", "foo@aarch64"}},
+        {"arch", Query{Arch: "aarch64"}, []string{"bar@aarch64", "foo@aarch64"}},
+        {"status", Query{Status: StatusSuccess}, []string{"foo@x86_64", "baz@x86_64", "foo@aarch64"}},
+        {"since includes its start", Query{Since: base.Add(time.Hour)},
+            []string{"bar@aarch64", "baz@x86_64", "foo@x86_64", "foo@aarch64"}},
+        {"until excludes its start", Query{Until: base.Add(2 * time.Hour)},
+            []string{"foo@x86_64", "bar@aarch64", "baz@x86_64"}},
+        {"between", Query{Since: base.Add(30 * time.Minute), Until: base.Add(150 * time.Minute)},
+            []string{"bar@aarch64", "baz@x86_64", "foo@x86_64"}},
+        {"everything together", Query{Pkgname: "foo", Arch: "x86_64", Status: StatusFailed,
+            Since: base.Add(time.Minute), Until: base.Add(3 * time.Hour)}, []string{"foo@x86_64"}},
+        {"since after everything", Query{Since: base.Add(4 * time.Hour)}, nil},
+        {"until before everything", Query{Until: base}, nil},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            records, err := hist.Find(test.query)
+            if err != nil {
+                t.Fatal(err)
+            }
+            var got []string
+            for _, record := range records {
+                got = append(got, record.Pkgname + "@" + record.Arch)
+            }
+            if str.Join(got, " ") != str.Join(test.want, " ") {
+                t.Errorf("found %q, want %q", got, test.want)
+            }
+        })
+    }
+}
+
+func TestLastDurations(t *testing.T) {
+    base := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+    hist := Open(filepath.Join(t.TempDir(), "history.db"))
+    for _, record := range []Record{
+        {Pkgname: "foo", Arch: "x86_64", Started: base, Duration: time.Minute, Status: StatusSuccess},
+        {Pkgname: "foo", Arch: "x86_64", Started: base.Add(time.Hour), Duration: 2 * time.Minute,
+            Status: StatusSuccess},
+        // Failures say nothing about how long a build takes
+        {Pkgname: "foo", Arch: "x86_64", Started: base.Add(2 * time.Hour), Duration: time.Second,
+            Status: StatusFailed},
+        {Pkgname: "bar", Arch: "x86_64", Started: base, Duration: time.Hour, Status: StatusTimeout},
+    } {
+        err := hist.Add(record)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    durations, err := hist.LastDurations()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(durations) != 1 || durations["foo@x86_64"] != 2 * time.Minute {
+        t.Errorf("durations are %v", durations)
+    }
+}
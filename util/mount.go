@@ -8,6 +8,7 @@ import (
 )
 
 const TMPFS_MAGIC = 0x01021994
+const OVERLAYFS_MAGIC = 0x794c7630
 
 // Note: These mount functions assume root.
 
@@ -136,3 +137,32 @@ func Unmount(directory string) error {
 
     return nil
 }
+
+// Mount an overlay of upper (with work as its work directory) on lower at
+// directory
+// upper and work must be on the same filesystem.
+func MountOverlay(directory string, lower string, upper string, work string) error {
+    err := unix.Mount("overlay", directory, "overlay", 0,
+        "lowerdir=" + lower + ",upperdir=" + upper + ",workdir=" + work)
+    if err != nil {
+        return fmt.Errorf("Error %w mounting overlay on %s", err, directory)
+    }
+    return nil
+}
+
+// Check if an overlay is mounted on a directory
+func IsOverlay(directory string) bool {
+    var statfs unix.Statfs_t
+    err := unix.Statfs(directory, &statfs)
+    return err == nil && statfs.Type == OVERLAYFS_MAGIC
+}
+
+// Unmount an overlay
+// Unlike Unmount, this does not need zramctl.
+func UnmountOverlay(directory string) error {
+    err := unix.Unmount(directory, 0)
+    if err != nil {
+        return fmt.Errorf("Error %w unmounting overlay %s", err, directory)
+    }
+    return nil
+}
@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package util
+
+import (
+    "errors"
+    "fmt"
+    "os/exec"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// A command ran for longer than it was allowed to
+var ErrTimeout = errors.New("timed out")
+
+// Run a command, killing it and everything it started if it takes longer than
+// timeout (0 means forever)
+// The command is put in its own process group so the whole tree can be
+// killed. On a timeout, the error wraps ErrTimeout.
+func RunTimeout(cmd *exec.Cmd, timeout time.Duration) error {
+    if cmd.SysProcAttr == nil {
+        cmd.SysProcAttr = &syscall.SysProcAttr{}
+    }
+    cmd.SysProcAttr.Setpgid = true
+
+    err := cmd.Start()
+    if err != nil {
+        return err
+    }
+
+    var mutex sync.Mutex
+    timedOut := false
+    if timeout > 0 {
+        timer := time.AfterFunc(timeout, func() {
+            mutex.Lock()
+            timedOut = true
+            mutex.Unlock()
+            // The process group has the same ID as its leader
+            syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+        })
+        defer timer.Stop()
+    }
+
+    err = cmd.Wait()
+    mutex.Lock()
+    defer mutex.Unlock()
+    if timedOut {
+        return fmt.Errorf("%w after %s", ErrTimeout, timeout)
+    }
+    return err
+}
@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package util
+
+import (
+    "errors"
+    "io/ioutil"
+    "os/exec"
+    "strconv"
+    str "strings"
+    "syscall"
+    "testing"
+    "time"
+)
+
+// Wait for a process to be gone (or a zombie), up to a second
+func processGone(pid int) bool {
+    for i := 0; i < 100; i++ {
+        if syscall.Kill(pid, 0) == syscall.ESRCH {
+            return true
+        }
+        stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+        if err != nil {
+            return true
+        }
+        // The state comes after the command name in brackets
+        fields := str.Fields(string(stat[str.LastIndex(string(stat[:]), ")") + 1:]))
+        if len(fields) > 0 && fields[0] == "Z" {
+            return true
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    return false
+}
+
+// Read a pid written to a file by a test command
+func readPid(t *testing.T, fname string) int {
+    data, err := ioutil.ReadFile(fname)
+    if err != nil {
+        t.Fatal(err)
+    }
+    pid, err := strconv.Atoi(str.TrimSpace(string(data[:])))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return pid
+}
+
+func TestRunTimeoutKillsTree(t *testing.T) {
+    pidFile := t.TempDir() + "/pid"
+    cmd := exec.Command("sh", "-c", "sleep 60 & echo $! > '" + pidFile + "'; sleep 60")
+
+    start := time.Now()
+    err := RunTimeout(cmd, 200 * time.Millisecond)
+    if took := time.Since(start); took > 5 * time.Second {
+        t.Errorf("took %s to time out", took)
+    }
+    if !errors.Is(err, ErrTimeout) {
+        t.Errorf("error is %v, want a timeout", err)
+    }
+    if !processGone(cmd.Process.Pid) {
+        t.Errorf("command is still running")
+    }
+    if !processGone(readPid(t, pidFile)) {
+        t.Errorf("process started by the command is still running")
+    }
+}
+
+func TestRunTimeout(t *testing.T) {
+    tests := []struct {
+        name string
+        script string
+        timeout time.Duration
+        wantErr bool
+    }{
+        {"success", "true", time.Minute, false},
+        {"failure", "exit 3", time.Minute, true},
+        {"no timeout", "sleep 0.1", 0, false},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            err := RunTimeout(exec.Command("sh", "-c", test.script), test.timeout)
+            if (err != nil) != test.wantErr {
+                t.Errorf("error is %v", err)
+            }
+            if errors.Is(err, ErrTimeout) {
+                t.Errorf("error %v is a timeout", err)
+            }
+        })
+    }
+}
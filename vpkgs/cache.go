@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package vpkgs
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+)
+
+// Version of the cache format, to be increased whenever Pkg changes
+const cacheVersion = 2
+
+// A cached dbulk-dump
+type cacheEntry struct {
+    Version int
+    // Hash of the template the dump was made from
+    Hash string
+    Pkg Pkg
+}
+
+// Path to the cache file for an identifier
+func cacheFile(ident string, cfg cfg.Cfgs) string {
+    return cfg.CachePath + "/dbulk-dump/" + ident + ".json"
+}
+
+// Get a cached dbulk-dump, if there is one for this version of the template
+func cacheGet(ident string, hash string, cfg cfg.Cfgs) (Pkg, bool) {
+    if !cfg.Cache {
+        return Pkg{}, false
+    }
+
+    // Any problem reading the cache just means we don't have it
+    data, err := ioutil.ReadFile(cacheFile(ident, cfg))
+    if err != nil {
+        return Pkg{}, false
+    }
+    entry := cacheEntry{}
+    err = json.Unmarshal(data, &entry)
+    if err != nil || entry.Version != cacheVersion || entry.Hash != hash {
+        return Pkg{}, false
+    }
+
+    return entry.Pkg, true
+}
+
+// Cache a dbulk-dump
+// Failing to cache is not fatal, so this only warns.
+func cachePut(ident string, hash string, pkg Pkg, cfg cfg.Cfgs) {
+    if !cfg.Cache {
+        return
+    }
+
+    fname := cacheFile(ident, cfg)
+    data, err := json.Marshal(cacheEntry{Version: cacheVersion, Hash: hash, Pkg: pkg})
+    if err == nil {
+        err = os.MkdirAll(cfg.CachePath + "/dbulk-dump", 0755)
+    }
+    if err == nil {
+        // Write to a temporary file first so we never leave a half-written entry
+        err = ioutil.WriteFile(fname + ".tmp", data, 0644)
+    }
+    if err == nil {
+        err = os.Rename(fname + ".tmp", fname)
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "WARN: Unable to cache dbulk-dump of %s: %s\n", ident, err)
+    }
+}
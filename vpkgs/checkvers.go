@@ -10,18 +10,28 @@ import (
     "os/exec"
     str "strings"
     "fmt"
+    "sync"
 )
 
 // Vers struct
 type Vers struct {
     all []string
     outdated []string
+    // Packages that are outdated or not present, by name
+    notReady map[string]bool
+    // Version of each package in the repository ("?" if not present)
+    versions map[string]string
 }
 
+// Results of checkvers for each arch, shared by everything in a run
+var versCache = make(map[string]Vers)
+var versMutex sync.Mutex
+
 // Run xbps-checkvers for all packages
-func checkversAll(baseArgs []string) ([]string, error) {
+func checkversAll(baseArgs []string, env []string) ([]string, error) {
     args := append(baseArgs, "-s")
     cmd := exec.Command("xbps-checkvers", args...)
+    cmd.Env = env
     out, err := cmd.Output()
     if err != nil {
         return []string{}, fmt.Errorf("Error %w while running %v", err, cmd.Args)
@@ -30,8 +40,9 @@ func checkversAll(baseArgs []string) ([]string, error) {
 }
 
 // Run xbps-checkvers for outdated pacakges
-func checkversOutdated(baseArgs []string) ([]string, error) {
+func checkversOutdated(baseArgs []string, env []string) ([]string, error) {
     cmd := exec.Command("xbps-checkvers", baseArgs...)
+    cmd.Env = env
     out, err := cmd.Output()
     if err != nil {
         return []string{}, fmt.Errorf("Error %w while running %v", err, cmd.Args)
@@ -40,43 +51,88 @@ func checkversOutdated(baseArgs []string) ([]string, error) {
 }
 
 // Create a Vers struct
-func checkvers(arch string, cfg cfg.Cfgs) (Vers, error) {
+func runCheckvers(arch string, cfg cfg.Cfgs) (Vers, error) {
     var err error
-    vers := Vers{}
+    vers := Vers{notReady: make(map[string]bool), versions: make(map[string]string)}
 
-    // Add a subdirectory to the binpkg path
-    binpkgs := "/hostdir/binpkgs/"
-    subDir, subdirExists := cfg.SubRepos[arch]
-    if subdirExists {
-        binpkgs += subDir
-    }
-    baseArgs := []string{"-D", cfg.VpkgPath, "-R", cfg.VpkgPath + binpkgs, "-i"}
+    baseArgs := []string{"-D", cfg.VpkgPath, "-R", RepoPath(arch, cfg), "-i"}
 
-    // Set XBPS_TARGET_ARCH
-    err = os.Setenv("XBPS_TARGET_ARCH", arch)
-    if err != nil {
-        return vers, fmt.Errorf("Error %w setting XBPS_TARGET_ARCH=%s", err, arch)
-    }
+    // Set XBPS_TARGET_ARCH, only for checkvers itself
+    env := append(os.Environ(), "XBPS_TARGET_ARCH=" + arch)
 
     // Get xbps-checkvers for all pkgs
-    vers.all, err = checkversAll(baseArgs)
+    vers.all, err = checkversAll(baseArgs, env)
     if err != nil {
         return vers, err
     }
 
     // Get xbps-checkvers for outdated pkgs
-    vers.outdated, err = checkversOutdated(baseArgs)
+    vers.outdated, err = checkversOutdated(baseArgs, env)
+    if err != nil {
+        return vers, err
+    }
     // Remove the dumb empty element on the end
     vers.outdated = vers.outdated[:len(vers.outdated) - 1]
+
+    // Index what is not ready
+    // First, present and not up-to-date (updated package)
+    for _, line := range vers.outdated {
+        vers.notReady[str.Split(line, " ")[0]] = true
+    }
+    // Next, not present (new package)
+    for _, line := range vers.all {
+        fields := str.Split(line, " ")
+        if len(fields) < 2 {
+            continue
+        }
+        vers.versions[fields[0]] = fields[1]
+        if fields[1] == "?" {
+            vers.notReady[fields[0]] = true
+        }
+    }
+
+    return vers, nil
+}
+
+// Get the Vers struct of an arch, only running checkvers the first time
+func checkvers(arch string, cfg cfg.Cfgs) (Vers, error) {
+    versMutex.Lock()
+    defer versMutex.Unlock()
+
+    vers, exists := versCache[arch]
+    if exists {
+        return vers, nil
+    }
+    vers, err := runCheckvers(arch, cfg)
     if err != nil {
         return vers, err
     }
-
+    versCache[arch] = vers
     return vers, nil
 }
 
+// Forget the state of an arch, so checkvers is run again next time it is
+// needed (e.g. after a package was built)
+func InvalidateReady(arch string) {
+    versMutex.Lock()
+    defer versMutex.Unlock()
+    delete(versCache, arch)
+}
+
+// Forget the state of every arch (e.g. after the tree changed)
+func InvalidateAllReady() {
+    versMutex.Lock()
+    defer versMutex.Unlock()
+    versCache = make(map[string]Vers)
+}
+
+// Check if a package is present and up-to-date according to vers
+func (vers Vers) ready(pkgName string) bool {
+    return !vers.notReady[pkgName]
+}
+
 // Get the state of a package - either ready (true) or not (false)
-func pkgReady(ident string, cfg cfg.Cfgs) (bool, error) {
+func PkgReady(ident string, cfg cfg.Cfgs) (bool, error) {
     var err error
     arch := str.Split(ident, "@")[1]
     vers, err := checkvers(arch, cfg)
@@ -86,22 +142,7 @@ func pkgReady(ident string, cfg cfg.Cfgs) (bool, error) {
 
     pkgName := str.Split(ident, "@")[0] + ""
 
-    // First, handle case of present and not up-to-date (updated package)
-    for _, line := range vers.outdated {
-        if str.Split(line, " ")[0] == pkgName {
-            return false, nil
-        }
-    }
-
-    // Next, not present (new package)
-    for _, line := range vers.all {
-        if str.Split(line, " ")[0] == pkgName && str.Split(line, " ")[1] == "?" {
-            return false, nil
-        }
-    }
-
-    // If we get here, it must be present and up-to-date
-    return true, nil
+    return vers.ready(pkgName), nil
 }
 
 // Check if there are any not up to date packages, and list them
@@ -11,10 +11,19 @@ import (
 
 // Pkg struct
 type Pkg struct {
+    // version_revision
+    Version         string
     Hostmakedepends []string
     Makedepends     []string
     Depends         []string
     Subpackages     []string
+    // archs=noarch
+    Noarch          bool
+    // Restrictions on building from the template
+    Archs           string
+    Nocross         string
+    Broken          string
+    Restricted      bool
     Ready           bool
 }
 
@@ -28,17 +37,48 @@ func readDbulkDumpList(out []string, i int) ([]string, int) {
 }
 
 // Translate dbulk-dump into a readable format
+// Results are cached on disk against the hash of the template.
 func DbulkDump(ident string, cfg cfg.Cfgs) (Pkg, error) {
     var err error
+
+    // Check if the package is ready
+    // This depends on the repository, so is never cached
+    ready, err := PkgReady(ident, cfg)
+    if err != nil {
+        return Pkg{}, err
+    }
+
+    hash, err := TemplateHash(str.Split(ident, "@")[0], cfg)
+    if err != nil {
+        return Pkg{}, err
+    }
+    pkg, found := cacheGet(ident, hash, cfg)
+    if !found {
+        pkg, err = dbulkDump(ident, cfg)
+        if err != nil {
+            return Pkg{}, err
+        }
+        cachePut(ident, hash, pkg, cfg)
+    }
+
+    pkg.Ready = ready
+    return pkg, nil
+}
+
+// Run and parse dbulk-dump
+func dbulkDump(ident string, cfg cfg.Cfgs) (Pkg, error) {
+    var err error
     var emptyStrSli []string = nil
 
     // Create the pkg to be returned
     pkg := Pkg{}
 
-    // Check if the package is ready
-    pkg.Ready, err = pkgReady(ident, cfg)
-    if err != nil {
-        return pkg, err
+    // We need a masterdir now
+    if !MasterdirExists(cfg) {
+        err = CreateMasterdir(cfg.MountDefault, cfg)
+        if err != nil {
+            return pkg, err
+        }
     }
 
     // Execute dbulk-dump
@@ -51,8 +91,9 @@ func DbulkDump(ident string, cfg cfg.Cfgs) (Pkg, error) {
     out := str.Split(string(bOut[:]), "\n")
 
     // Parse dbulk-dump
+    // Skip pkgName, read version and revision
+    pkg.Version = str.TrimPrefix(out[1], "version: ") + "_" + str.TrimPrefix(out[2], "revision: ")
     i := 3
-    // Skip pkgName, version, revision
     // Skip bootstrap (if it exists!)
     if str.HasPrefix(out[i], "bootstrap: ") {
         i++
@@ -86,5 +127,16 @@ func DbulkDump(ident string, cfg cfg.Cfgs) (Pkg, error) {
         pkg.Subpackages = emptyStrSli
     }
 
+    // Anything else comes from the template itself
+    vars, err := templateVars(pkgName, cfg)
+    if err != nil {
+        return Pkg{}, err
+    }
+    pkg.Archs = vars["archs"]
+    pkg.Noarch = pkg.Archs == "noarch"
+    pkg.Nocross = vars["nocross"]
+    pkg.Broken = vars["broken"]
+    pkg.Restricted = vars["restricted"] != ""
+
     return pkg, nil
 }
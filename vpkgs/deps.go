@@ -0,0 +1,168 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package vpkgs
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "bufio"
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "regexp"
+    str "strings"
+    "sync"
+)
+
+// A single entry of a dependency list
+type Dep struct {
+    // Package name, with any virtual dependency resolved
+    Name string
+    // The virtual package name, if this was a virtual dependency
+    Virtual string
+    // Version constraint as a pattern for xbps (e.g. foo>=1.2_1), empty if
+    // there is none
+    Constraint string
+}
+
+// An exact version (e.g. foo-1.2_1) or version glob (e.g. foo-1.2*)
+var depVersionRe = regexp.MustCompile(`^(.+)-([^-]*[0-9*][^-]*)$`)
+
+// Virtual package providers of each void-packages, read once per run
+var providersCache = make(map[string]map[string]string)
+var providersMutex sync.Mutex
+
+// Read the virtual package providers of void-packages
+// etc/virtual (local overrides) takes priority over etc/defaults.virtual.
+func readVirtualProviders(cfg cfg.Cfgs) (map[string]string, error) {
+    providers := make(map[string]string)
+
+    for _, fname := range []string{"/etc/defaults.virtual", "/etc/virtual"} {
+        f, err := os.Open(cfg.VpkgPath + fname)
+        if os.IsNotExist(err) {
+            continue
+        } else if err != nil {
+            return providers, fmt.Errorf("Error %w opening %s", err, cfg.VpkgPath + fname)
+        }
+
+        scanner := bufio.NewScanner(f)
+        for scanner.Scan() {
+            line := str.TrimSpace(scanner.Text())
+            if line == "" || str.HasPrefix(line, "#") {
+                continue
+            }
+            // virtual-name provider
+            fields := str.Fields(line)
+            if len(fields) < 2 {
+                continue
+            }
+            providers[fields[0]] = fields[1]
+        }
+        f.Close()
+        if scanner.Err() != nil {
+            return providers, fmt.Errorf("Error %w reading %s", scanner.Err(), cfg.VpkgPath + fname)
+        }
+    }
+
+    return providers, nil
+}
+
+// Get the virtual package providers, only reading them the first time
+func virtualProviders(cfg cfg.Cfgs) (map[string]string, error) {
+    providersMutex.Lock()
+    defer providersMutex.Unlock()
+
+    providers, exists := providersCache[cfg.VpkgPath]
+    if exists {
+        return providers, nil
+    }
+    providers, err := readVirtualProviders(cfg)
+    if err != nil {
+        return providers, err
+    }
+    providersCache[cfg.VpkgPath] = providers
+    return providers, nil
+}
+
+// Split a dependency into the package name and version constraint
+func splitConstraint(dep string) (string, string) {
+    // foo>=1.2_1, foo<2, etc
+    if i := str.IndexAny(dep, "<>="); i > 0 {
+        return dep[:i], dep
+    }
+    // foo-1.2_1, foo-1.2*
+    match := depVersionRe.FindStringSubmatch(dep)
+    if match != nil && str.ContainsAny(match[2], "._*") {
+        return match[1], dep
+    }
+    return dep, ""
+}
+
+// Parse a dependency list as given by dbulk-dump
+func ParseDeps(deps []string, cfg cfg.Cfgs) ([]Dep, error) {
+    var parsed []Dep
+
+    providers, err := virtualProviders(cfg)
+    if err != nil {
+        return parsed, err
+    }
+
+    for _, entry := range deps {
+        dep := Dep{}
+
+        // virtual?foo means anything providing foo
+        if str.HasPrefix(entry, "virtual?") {
+            entry = str.TrimPrefix(entry, "virtual?")
+            virtual, constraint := splitConstraint(entry)
+            provider, exists := providers[virtual]
+            if !exists {
+                return parsed, fmt.Errorf("No default provider for virtual package %s", virtual)
+            }
+            dep.Virtual = virtual
+            dep.Name = provider
+            // The provider's version has to satisfy the constraint instead
+            if constraint != "" {
+                dep.Constraint = provider + str.TrimPrefix(constraint, virtual)
+            }
+            parsed = append(parsed, dep)
+            continue
+        }
+
+        dep.Name, dep.Constraint = splitConstraint(entry)
+        parsed = append(parsed, dep)
+    }
+
+    return parsed, nil
+}
+
+// Check if the version of a dependency in the repository satisfies its
+// constraint
+// basePkg is the base package of the dependency, used to find the version.
+// Packages not in the repository are never satisfied.
+func Satisfied(dep Dep, basePkg string, arch string, cfg cfg.Cfgs) (bool, error) {
+    if dep.Constraint == "" {
+        return true, nil
+    }
+
+    vers, err := checkvers(arch, cfg)
+    if err != nil {
+        return false, err
+    }
+    version, exists := vers.versions[basePkg]
+    if !exists || version == "?" {
+        return false, nil
+    }
+
+    // xbps-uhelper exits 1 on a match and 0 on a mismatch
+    cmd := exec.Command("xbps-uhelper", "pkgmatch", dep.Name + "-" + version, dep.Constraint)
+    err = cmd.Run()
+    var exitErr *exec.ExitError
+    if err == nil {
+        return false, nil
+    } else if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+        return true, nil
+    }
+    return false, fmt.Errorf("Error %w while running %v", err, cmd.Args)
+}
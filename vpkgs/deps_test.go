@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package vpkgs
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "io/ioutil"
+    "os"
+    "testing"
+)
+
+func TestSplitConstraint(t *testing.T) {
+    tests := []struct {
+        dep string
+        name string
+        constraint string
+    }{
+        {"foo", "foo", ""},
+        {"foo>=1.2_1", "foo", "foo>=1.2_1"},
+        {"foo<2", "foo", "foo<2"},
+        {"foo>1.0<2.0", "foo", "foo>1.0<2.0"},
+        {"foo-1.2_1", "foo", "foo-1.2_1"},
+        {"foo-1.2*", "foo", "foo-1.2*"},
+        {"foo-bar", "foo-bar", ""},
+        {"perl-Foo-Bar", "perl-Foo-Bar", ""},
+        {"python3-foo-1.0_1", "python3-foo", "python3-foo-1.0_1"},
+        // A dash and a number isn't a version without a . _ or *
+        {"font-misc-10x20", "font-misc-10x20", ""},
+    }
+    for _, test := range tests {
+        t.Run(test.dep, func(t *testing.T) {
+            name, constraint := splitConstraint(test.dep)
+            if name != test.name || constraint != test.constraint {
+                t.Errorf("got %q, %q, want %q, %q", name, constraint, test.name, test.constraint)
+            }
+        })
+    }
+}
+
+// Create a void-packages with the given virtual package files
+func testVirtualVpkgs(t *testing.T, defaults string, local string) cfg.Cfgs {
+    vpkgPath := t.TempDir()
+    err := os.Mkdir(vpkgPath + "/etc", 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = ioutil.WriteFile(vpkgPath + "/etc/defaults.virtual", []byte(defaults), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if local != "" {
+        err = ioutil.WriteFile(vpkgPath + "/etc/virtual", []byte(local), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    return cfg.Cfgs{VpkgPath: vpkgPath}
+}
+
+func TestParseDeps(t *testing.T) {
+    defaults := "# comment\n\njava-runtime openjdk8-jre\nawk gawk\nntp-daemon chrony\n"
+    tests := []struct {
+        name string
+        local string
+        deps []string
+        want []Dep
+        wantErr bool
+    }{
+        {"plain", "", []string{"foo", "bar>=1.0_1"},
+            []Dep{{Name: "foo"}, {Name: "bar", Constraint: "bar>=1.0_1"}}, false},
+        {"virtual", "", []string{"virtual?awk"},
+            []Dep{{Name: "gawk", Virtual: "awk"}}, false},
+        {"virtual constraint applies to the provider", "", []string{"virtual?java-runtime>=8"},
+            []Dep{{Name: "openjdk8-jre", Virtual: "java-runtime", Constraint: "openjdk8-jre>=8"}}, false},
+        {"local override", "awk mawk\n", []string{"virtual?awk", "virtual?ntp-daemon"},
+            []Dep{{Name: "mawk", Virtual: "awk"}, {Name: "chrony", Virtual: "ntp-daemon"}}, false},
+        {"no provider", "", []string{"virtual?nothing"}, nil, true},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            cfg := testVirtualVpkgs(t, defaults, test.local)
+            got, err := ParseDeps(test.deps, cfg)
+            if test.wantErr {
+                if err == nil {
+                    t.Errorf("got %v, want an error", got)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(got) != len(test.want) {
+                t.Fatalf("got %v, want %v", got, test.want)
+            }
+            for i := range got {
+                if got[i] != test.want[i] {
+                    t.Errorf("dep %d is %+v, want %+v", i, got[i], test.want[i])
+                }
+            }
+        })
+    }
+}
+
+func TestVirtualProvidersReadOnce(t *testing.T) {
+    cfg := testVirtualVpkgs(t, "awk gawk\n", "")
+    _, err := ParseDeps([]string{"virtual?awk"}, cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Changing the files in the middle of a run makes no difference
+    err = ioutil.WriteFile(cfg.VpkgPath + "/etc/virtual", []byte("awk mawk\n"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    deps, err := ParseDeps([]string{"virtual?awk"}, cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if deps[0].Name != "gawk" {
+        t.Errorf("etc/virtual was read again, got %s", deps[0].Name)
+    }
+}
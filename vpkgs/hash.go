@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package vpkgs
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "crypto/sha256"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+)
+
+// Add a file (name and contents) to a hash
+func hashFile(h io.Writer, path string, name string) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return fmt.Errorf("Error %w opening %s", err, path)
+    }
+    defer f.Close()
+
+    // Include the name so that renames change the hash
+    _, err = io.WriteString(h, name + "\x00")
+    if err != nil {
+        return fmt.Errorf("Error %w hashing %s", err, path)
+    }
+    _, err = io.Copy(h, f)
+    if err != nil {
+        return fmt.Errorf("Error %w hashing %s", err, path)
+    }
+    return nil
+}
+
+// Hash the template of a package, along with its files/ and patches/
+// Subpackages give the hash of their base package.
+func TemplateHash(pkgName string, cfg cfg.Cfgs) (string, error) {
+    h := sha256.New()
+
+    pkgDir, err := filepath.EvalSymlinks(cfg.VpkgPath + "/srcpkgs/" + pkgName)
+    if err != nil {
+        return "", fmt.Errorf("Error %w resolving %s", err, pkgName)
+    }
+
+    err = hashFile(h, pkgDir + "/template", "template")
+    if err != nil {
+        return "", err
+    }
+
+    // filepath.Walk goes in lexical order, so this is stable
+    for _, sub := range []string{"files", "patches"} {
+        _, err = os.Stat(pkgDir + "/" + sub)
+        if os.IsNotExist(err) {
+            continue
+        }
+        err = filepath.Walk(pkgDir + "/" + sub, func(path string, info os.FileInfo, err error) error {
+            if err != nil {
+                return err
+            }
+            if !info.Mode().IsRegular() {
+                return nil
+            }
+            name, err := filepath.Rel(pkgDir, path)
+            if err != nil {
+                return err
+            }
+            return hashFile(h, path, name)
+        })
+        if err != nil {
+            return "", fmt.Errorf("Error %w hashing %s of %s", err, sub, pkgName)
+        }
+    }
+
+    return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
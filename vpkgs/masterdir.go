@@ -8,25 +8,62 @@ import (
     "github.com/fosslinux/vxb/cfg"
     "os"
     "fmt"
+    "path/filepath"
 )
 
+// Path to the masterdir in use
+func MasterdirPath(cfg cfg.Cfgs) string {
+    return cfg.VpkgPath + "/" + cfg.Masterdir
+}
+
+// Check if the masterdir in use exists
+func MasterdirExists(cfg cfg.Cfgs) bool {
+    _, err := os.Lstat(MasterdirPath(cfg))
+    return err == nil
+}
+
+// Check if a masterdir on a mount gets its own subdirectory of it
+// An overlay can't be mounted on the mount itself, as its layers live there.
+func ownMountSubdir(cfg cfg.Cfgs) bool {
+    return cfg.Masterdir != "masterdir" || cfg.Snapshot == "overlay"
+}
+
 // Create (i.e. binary-bootstrap) a masterdir
 func CreateMasterdir(mountType string, cfg cfg.Cfgs) error {
     var err error
+    masterdir := MasterdirPath(cfg)
+    // Where the masterdir really is
+    dir := masterdir
 
     // Check if we need to handle different types of masterdirs
     if mountType == "none" {
         // Make the actual directory
-        err = os.Mkdir(cfg.VpkgPath + "/masterdir", 0755)
+        err = os.Mkdir(masterdir, 0755)
         if err != nil {
             return fmt.Errorf("Unable to create masterdir directory with %w", err)
         }
     } else {
         // Make the appropriate symlink
-        err = os.Symlink("mnt/" + mountType, cfg.VpkgPath + "/masterdir")
+        // Non-default masterdirs share the mount, so they each get their own
+        // subdirectory of it
+        target := "mnt/" + mountType
+        if ownMountSubdir(cfg) {
+            target += "/" + cfg.Masterdir
+            err = os.Mkdir(cfg.VpkgPath + "/" + target, 0755)
+            if err != nil {
+                return fmt.Errorf("Unable to create %s with %w", target, err)
+            }
+        }
+        err = os.Symlink(target, masterdir)
         if err != nil {
             return fmt.Errorf("Unable to create symlink for masterdir with %w", err)
         }
+        dir = cfg.VpkgPath + "/" + target
+    }
+
+    // Clone the pristine masterdir if we can
+    if cfg.Snapshot != "none" {
+        return cloneMasterdir(dir, cfg)
     }
 
     // Bootstrap the actual masterdir
@@ -40,26 +77,46 @@ func CreateMasterdir(mountType string, cfg cfg.Cfgs) error {
 // Remove a masterdir
 func RemoveMasterdir(cfg cfg.Cfgs) error {
     var err error
+    masterdir := MasterdirPath(cfg)
+
+    // A cloned overlay has to be unmounted first, leaving its layers to remove
+    dir, err := filepath.EvalSymlinks(masterdir)
+    if err == nil {
+        err = removeOverlay(dir)
+        if err != nil {
+            return err
+        }
+    }
 
     // Remove all subdirectories/files
-    vpkgDir, err := os.Open(cfg.VpkgPath + "/masterdir")
+    vpkgDir, err := os.Open(masterdir)
     if err != nil {
-        return fmt.Errorf("Error %w opening %s", err, cfg.VpkgPath + "/masterdir")
+        return fmt.Errorf("Error %w opening %s", err, masterdir)
     }
     within, err := vpkgDir.Readdir(0)
+    vpkgDir.Close()
     if err != nil {
-        return fmt.Errorf("Error %w listing subfiles/directories within %s", err, cfg.VpkgPath + "/masterdir")
+        return fmt.Errorf("Error %w listing subfiles/directories within %s", err, masterdir)
     }
     for _, f := range within {
         // Remove each
-        err = os.RemoveAll(cfg.VpkgPath + "/masterdir/" + f.Name())
+        err = os.RemoveAll(masterdir + "/" + f.Name())
+        if err != nil {
+            return fmt.Errorf("Unable to remove %s with %w", masterdir + "/" + f.Name(), err)
+        }
+    }
+
+    // Non-default masterdirs on a mount have their own subdirectory to remove
+    target, linkErr := os.Readlink(masterdir)
+    if linkErr == nil && ownMountSubdir(cfg) {
+        err = os.Remove(cfg.VpkgPath + "/" + target)
         if err != nil {
-            return fmt.Errorf("Unable to remove %s with %w", cfg.VpkgPath + "/masterdir/" + f.Name(), err)
+            return fmt.Errorf("Unable to remove %s with %w", target, err)
         }
     }
 
     // Finally, remove the directory itself
-    err = os.RemoveAll(cfg.VpkgPath + "/masterdir")
+    err = os.RemoveAll(masterdir)
     if err != nil {
         return fmt.Errorf("Unable to remove masterdir with %w", err)
     }
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package vpkgs
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+    str "strings"
+)
+
+// Path to the local repository of an arch
+func RepoPath(arch string, cfg cfg.Cfgs) string {
+    binpkgs := cfg.VpkgPath + "/hostdir/binpkgs"
+    subDir, subdirExists := cfg.SubRepos[arch]
+    if subdirExists {
+        binpkgs += "/" + subDir
+    }
+    return binpkgs
+}
+
+// Copy a file
+func copyFile(src string, dst string) error {
+    in, err := os.Open(src)
+    if err != nil {
+        return fmt.Errorf("Error %w opening %s", err, src)
+    }
+    defer in.Close()
+
+    out, err := os.Create(dst)
+    if err != nil {
+        return fmt.Errorf("Error %w creating %s", err, dst)
+    }
+    _, err = io.Copy(out, in)
+    if err != nil {
+        out.Close()
+        return fmt.Errorf("Error %w copying %s to %s", err, src, dst)
+    }
+    return out.Close()
+}
+
+// Register the binpkgs of a noarch package built for the host in the
+// repository of another arch, so that it does not need to be built again
+func RegisterNoarch(pkgName string, pkg Pkg, arch string, cfg cfg.Cfgs) error {
+    hostRepo := RepoPath(cfg.HostArch, cfg)
+    repo := RepoPath(arch, cfg)
+
+    var binpkgs []string
+    for _, name := range append([]string{pkgName}, pkg.Subpackages...) {
+        binpkg := name + "-" + pkg.Version + ".noarch.xbps"
+        // It may need to be put in the right repository first
+        if repo != hostRepo {
+            err := os.MkdirAll(repo, 0755)
+            if err != nil {
+                return fmt.Errorf("Unable to create %s with %w", repo, err)
+            }
+            err = copyFile(hostRepo + "/" + binpkg, repo + "/" + binpkg)
+            if err != nil {
+                return err
+            }
+        }
+        binpkgs = append(binpkgs, repo + "/" + binpkg)
+    }
+
+    return IndexBinpkgs(binpkgs, arch)
+}
+
+// Add binpkgs to the repository index of an arch
+func IndexBinpkgs(binpkgs []string, arch string) error {
+    // xbps-rindex uses XBPS_TARGET_ARCH to pick the repodata
+    cmd := exec.Command("xbps-rindex", append([]string{"-a"}, binpkgs...)...)
+    cmd.Env = append(os.Environ(), "XBPS_TARGET_ARCH=" + arch)
+    out, err := cmd.CombinedOutput()
+    if err != nil {
+        fmt.Printf("%s\n", string(out[:]))
+        return fmt.Errorf("Error %w while executing %s", err, cmd.Args)
+    }
+
+    InvalidateReady(arch)
+    return nil
+}
+
+// Arch of a binpkg from its file name (foo-1.0_1.x86_64.xbps)
+// noarch binpkgs are given the fallback arch.
+func BinpkgArch(fname string, fallback string) string {
+    fname = str.TrimSuffix(filepath.Base(fname), ".xbps")
+    arch := fname[str.LastIndex(fname, ".") + 1:]
+    if arch == "noarch" {
+        return fallback
+    }
+    return arch
+}
+
+// List every binpkg in every local repository, giving the size of each by its
+// path relative to hostdir/binpkgs
+func ListBinpkgs(cfg cfg.Cfgs) (map[string]int64, error) {
+    binpkgs := make(map[string]int64)
+    root := cfg.VpkgPath + "/hostdir/binpkgs"
+
+    err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        if info.IsDir() || !str.HasSuffix(path, ".xbps") {
+            return nil
+        }
+        rel, err := filepath.Rel(root, path)
+        if err != nil {
+            return err
+        }
+        binpkgs[rel] = info.Size()
+        return nil
+    })
+    if os.IsNotExist(err) {
+        return binpkgs, nil
+    } else if err != nil {
+        return binpkgs, fmt.Errorf("Error %w listing binpkgs in %s", err, root)
+    }
+    return binpkgs, nil
+}
@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package vpkgs
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+)
+
+// Get the base package of a package name from srcpkgs/ alone
+// This is cheaper than ResolveSubpackage, but does not check the subpackage
+// is enabled for any particular arch.
+func basePkg(pkgName string, cfg cfg.Cfgs) string {
+    target, err := os.Readlink(cfg.VpkgPath + "/srcpkgs/" + pkgName)
+    if err != nil {
+        return pkgName
+    }
+    return filepath.Base(target)
+}
+
+// Build an index of the packages depending on each (base) package
+func revdepIndex(arch string, progress io.Writer, cfg cfg.Cfgs) (map[string][]string, error) {
+    index := make(map[string][]string)
+
+    pkgNames, err := AllPkgs(cfg)
+    if err != nil {
+        return index, err
+    }
+
+    fmt.Fprintf(progress, "Indexing reverse dependencies of %d packages...\n", len(pkgNames))
+    for _, pkgName := range pkgNames {
+        dump, err := DbulkDump(pkgName + "@" + arch, cfg)
+        if err != nil {
+            return index, err
+        }
+
+        var all []string
+        all = append(all, dump.Hostmakedepends...)
+        all = append(all, dump.Makedepends...)
+        all = append(all, dump.Depends...)
+        deps, err := ParseDeps(all, cfg)
+        if err != nil {
+            return index, fmt.Errorf("%w in dependencies of %s", err, pkgName)
+        }
+
+        seen := make(map[string]bool)
+        for _, dep := range deps {
+            depBase := basePkg(dep.Name, cfg)
+            if seen[depBase] || depBase == pkgName {
+                continue
+            }
+            seen[depBase] = true
+            index[depBase] = append(index[depBase], pkgName)
+        }
+    }
+
+    return index, nil
+}
+
+// Find every package that depends (directly or not) on any of the given
+// packages for an arch
+func Revdeps(pkgNames []string, arch string, progress io.Writer, cfg cfg.Cfgs) ([]string, error) {
+    var revdeps []string
+
+    index, err := revdepIndex(arch, progress, cfg)
+    if err != nil {
+        return revdeps, err
+    }
+
+    // Breadth-first through consumers
+    seen := make(map[string]bool)
+    var queue []string
+    for _, pkgName := range pkgNames {
+        pkgName = basePkg(pkgName, cfg)
+        seen[pkgName] = true
+        queue = append(queue, pkgName)
+    }
+    for len(queue) > 0 {
+        pkgName := queue[0]
+        queue = queue[1:]
+        for _, consumer := range index[pkgName] {
+            if seen[consumer] {
+                continue
+            }
+            seen[consumer] = true
+            revdeps = append(revdeps, consumer)
+            queue = append(queue, consumer)
+        }
+    }
+
+    return revdeps, nil
+}
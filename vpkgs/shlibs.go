@@ -0,0 +1,201 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package vpkgs
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "bufio"
+    "fmt"
+    "os"
+    "os/exec"
+    "sort"
+    str "strings"
+)
+
+// A package in the repository requiring a soname the repository no longer
+// provides
+type ShlibConsumer struct {
+    // pkgver of the package in the repository
+    Pkgver string
+    // Its base package in srcpkgs/
+    BasePkg string
+    // Sonames it requires that are no longer provided
+    Missing []string
+    // Packages common/shlibs says provide the same library, for hints
+    Providers []string
+}
+
+// Strip the version from a pkgver
+func pkgverName(pkgver string) string {
+    i := str.LastIndex(pkgver, "-")
+    if i <= 0 {
+        return pkgver
+    }
+    return pkgver[:i]
+}
+
+// Strip the version from a soname (libfoo.so.1 -> libfoo.so)
+func sonameBase(soname string) string {
+    i := str.Index(soname, ".so")
+    if i < 0 {
+        return soname
+    }
+    return soname[:i + 3]
+}
+
+// Read common/shlibs, giving the package providing each soname
+func readShlibs(cfg cfg.Cfgs) (map[string]string, error) {
+    shlibs := make(map[string]string)
+
+    fname := cfg.VpkgPath + "/common/shlibs"
+    f, err := os.Open(fname)
+    if err != nil {
+        return shlibs, fmt.Errorf("Error %w opening %s", err, fname)
+    }
+    defer f.Close()
+
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        line := str.TrimSpace(scanner.Text())
+        if line == "" || str.HasPrefix(line, "#") {
+            continue
+        }
+        // soname pkgver [ignore]
+        fields := str.Fields(line)
+        if len(fields) < 2 {
+            continue
+        }
+        shlibs[fields[0]] = pkgverName(fields[1])
+    }
+    if scanner.Err() != nil {
+        return shlibs, fmt.Errorf("Error %w reading %s", scanner.Err(), fname)
+    }
+
+    return shlibs, nil
+}
+
+// Get a property of every package in the local repository of an arch
+// Gives a list of values for each pkgver.
+func repoProperty(property string, arch string, cfg cfg.Cfgs) (map[string][]string, error) {
+    values := make(map[string][]string)
+
+    cmd := exec.Command("xbps-query", "-i", "-R", "--repository=" + RepoPath(arch, cfg),
+        "--regex", "-s", ".", "-p", property)
+    cmd.Env = append(os.Environ(), "XBPS_TARGET_ARCH=" + arch)
+    out, err := cmd.Output()
+    if err != nil {
+        return values, fmt.Errorf("Error %w while running %v", err, cmd.Args)
+    }
+
+    // Each line is pkgver: value
+    for _, line := range str.Split(string(out[:]), "\n") {
+        parts := str.SplitN(line, ": ", 2)
+        if len(parts) != 2 {
+            continue
+        }
+        value := str.Fields(parts[1])
+        if len(value) == 0 {
+            continue
+        }
+        values[parts[0]] = append(values[parts[0]], value[0])
+    }
+
+    return values, nil
+}
+
+// Check if a soname required by a package in the local repository has gone
+// A soname can only go if its provider is in the local repository and no
+// longer ships it. Sonames from packages vxb never built (e.g. libc.so.6)
+// come from elsewhere, so are fine. Sonames common/shlibs no longer lists
+// have gone if the library (under a new soname) is in the local repository.
+func sonameGone(soname string, provided map[string]bool, inRepo map[string]bool,
+    shlibs map[string]string, libProviders map[string]map[string]bool) bool {
+    if provided[soname] {
+        return false
+    }
+    if pkgName, listed := shlibs[soname]; listed {
+        return inRepo[pkgName]
+    }
+    for pkgName := range libProviders[sonameBase(soname)] {
+        if inRepo[pkgName] {
+            return true
+        }
+    }
+    return false
+}
+
+// Find packages in the local repository of an arch that require a soname
+// which the repository no longer provides
+// This happens when a library changes its soname but its consumers were not
+// revbumped.
+func ShlibConsumers(arch string, cfg cfg.Cfgs) ([]ShlibConsumer, error) {
+    var consumers []ShlibConsumer
+
+    shlibs, err := readShlibs(cfg)
+    if err != nil {
+        return consumers, err
+    }
+    requires, err := repoProperty("shlib-requires", arch, cfg)
+    if err != nil {
+        return consumers, err
+    }
+    provides, err := repoProperty("shlib-provides", arch, cfg)
+    if err != nil {
+        return consumers, err
+    }
+    pkgvers, err := repoProperty("pkgver", arch, cfg)
+    if err != nil {
+        return consumers, err
+    }
+
+    // What the repository has, and what its packages provide
+    inRepo := make(map[string]bool)
+    for pkgver := range pkgvers {
+        inRepo[pkgverName(pkgver)] = true
+    }
+    provided := make(map[string]bool)
+    for _, sonames := range provides {
+        for _, soname := range sonames {
+            provided[soname] = true
+        }
+    }
+    // Which packages provide a version of each library, according to
+    // common/shlibs
+    libProviders := make(map[string]map[string]bool)
+    for soname, pkgName := range shlibs {
+        base := sonameBase(soname)
+        if libProviders[base] == nil {
+            libProviders[base] = make(map[string]bool)
+        }
+        libProviders[base][pkgName] = true
+    }
+
+    for pkgver, sonames := range requires {
+        consumer := ShlibConsumer{Pkgver: pkgver, BasePkg: basePkg(pkgverName(pkgver), cfg)}
+        hints := make(map[string]bool)
+        for _, soname := range sonames {
+            if !sonameGone(soname, provided, inRepo, shlibs, libProviders) {
+                continue
+            }
+            consumer.Missing = append(consumer.Missing, soname)
+            for pkgName := range libProviders[sonameBase(soname)] {
+                hints[pkgName] = true
+            }
+        }
+        if len(consumer.Missing) == 0 {
+            continue
+        }
+        for pkgName := range hints {
+            consumer.Providers = append(consumer.Providers, pkgName)
+        }
+        sort.Strings(consumer.Providers)
+        consumers = append(consumers, consumer)
+    }
+
+    sort.Slice(consumers, func(i, j int) bool {
+        return consumers[i].Pkgver < consumers[j].Pkgver
+    })
+    return consumers, nil
+}
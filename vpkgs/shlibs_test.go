@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package vpkgs
+
+import (
+    "testing"
+)
+
+func TestSonameGone(t *testing.T) {
+    shlibs := map[string]string{
+        "libc.so.6": "glibc",
+        "libz.so.1": "zlib",
+        "libfoo.so.2": "foo",
+        "libbar.so.1": "bar",
+    }
+    libProviders := map[string]map[string]bool{
+        "libc.so": {"glibc": true},
+        "libz.so": {"zlib": true},
+        "libfoo.so": {"foo": true},
+        "libbar.so": {"bar": true},
+    }
+    // foo moved from libfoo.so.1 to libfoo.so.2, bar dropped libbar.so.1
+    inRepo := map[string]bool{"foo": true, "bar": true, "baz": true}
+    provided := map[string]bool{"libfoo.so.2": true}
+
+    tests := []struct {
+        soname string
+        gone bool
+    }{
+        {"libfoo.so.2", false},
+        {"libc.so.6", false},
+        {"libz.so.1", false},
+        {"libunknown.so.3", false},
+        {"libfoo.so.1", true},
+        {"libbar.so.1", true},
+    }
+    for _, test := range tests {
+        t.Run(test.soname, func(t *testing.T) {
+            got := sonameGone(test.soname, provided, inRepo, shlibs, libProviders)
+            if got != test.gone {
+                t.Errorf("gone is %t, want %t", got, test.gone)
+            }
+        })
+    }
+}
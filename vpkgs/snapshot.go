@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package vpkgs
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "github.com/fosslinux/vxb/util"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    str "strings"
+    "sync"
+)
+
+// Only one pristine masterdir is bootstrapped at a time
+var pristineMutex sync.Mutex
+
+// Name of the pristine masterdir of the host arch
+func pristineName(cfg cfg.Cfgs) string {
+    return "masterdir-pristine-" + cfg.HostArch
+}
+
+// Path to the pristine masterdir of the host arch
+// Masterdirs are cloned from it, rather than bootstrapped, when snapshots
+// are enabled.
+func PristinePath(cfg cfg.Cfgs) string {
+    return cfg.VpkgPath + "/" + pristineName(cfg)
+}
+
+// Make sure the pristine masterdir exists and is up to date
+// It is bootstrapped again whenever base-chroot (which binary-bootstrap
+// installs) changes, saying so on progress. Nothing may be cloned from it
+// while this runs.
+func RefreshPristine(progress io.Writer, cfg cfg.Cfgs) error {
+    if cfg.Snapshot == "none" {
+        return nil
+    }
+    pristineMutex.Lock()
+    defer pristineMutex.Unlock()
+
+    // The hash it was bootstrapped from is kept next to it
+    hash, err := TemplateHash("base-chroot", cfg)
+    if err != nil {
+        return err
+    }
+    hashPath := PristinePath(cfg) + ".hash"
+    oldHash, err := ioutil.ReadFile(hashPath)
+    pcfg := cfg
+    pcfg.Masterdir = pristineName(cfg)
+    if err == nil && str.TrimSpace(string(oldHash[:])) == hash && MasterdirExists(pcfg) {
+        return nil
+    }
+
+    fmt.Fprintf(progress, "Bootstrapping pristine masterdir for %s...\n", cfg.HostArch)
+    os.Remove(hashPath)
+    if MasterdirExists(pcfg) {
+        err = RemoveMasterdir(pcfg)
+        if err != nil {
+            return err
+        }
+    }
+    err = os.Mkdir(PristinePath(cfg), 0755)
+    if err != nil {
+        return fmt.Errorf("Unable to create %s with %w", PristinePath(cfg), err)
+    }
+    _, err = XbpsSrc("binary-bootstrap " + cfg.HostArch, cfg.HostArch, "", false, pcfg)
+    if err != nil {
+        return err
+    }
+
+    err = ioutil.WriteFile(hashPath, []byte(hash + "\n"), 0644)
+    if err != nil {
+        return fmt.Errorf("Error %w writing %s", err, hashPath)
+    }
+    return nil
+}
+
+// Where the layers of an overlay masterdir mounted on dir live
+func overlayPath(dir string) string {
+    return dir + ".overlay"
+}
+
+// Clone the pristine masterdir into dir
+// A reflink copy falls back to a normal copy where reflinks aren't
+// supported. An overlay keeps its changes next to dir, so on the same mount.
+func cloneMasterdir(dir string, cfg cfg.Cfgs) error {
+    pristine := PristinePath(cfg)
+    _, err := os.Stat(pristine)
+    if err != nil {
+        return fmt.Errorf("Pristine masterdir %s does not exist", pristine)
+    }
+
+    if cfg.Snapshot == "reflink" {
+        cmd := exec.Command("cp", "-a", "--reflink=auto", pristine + "/.", dir)
+        out, err := cmd.CombinedOutput()
+        if err != nil {
+            fmt.Printf("%s\n", string(out[:]))
+            return fmt.Errorf("Error %w cloning %s into %s", err, pristine, dir)
+        }
+        return nil
+    }
+
+    layers := overlayPath(dir)
+    for _, layer := range []string{"upper", "work"} {
+        err = os.MkdirAll(layers + "/" + layer, 0755)
+        if err != nil {
+            return fmt.Errorf("Unable to create %s with %w", layers + "/" + layer, err)
+        }
+    }
+    return util.MountOverlay(dir, pristine, layers + "/upper", layers + "/work")
+}
+
+// Unmount an overlay masterdir on dir, if there is one, and remove its layers
+func removeOverlay(dir string) error {
+    layers := overlayPath(dir)
+    _, err := os.Stat(layers)
+    if os.IsNotExist(err) {
+        return nil
+    }
+
+    if util.IsOverlay(dir) {
+        err = util.UnmountOverlay(dir)
+        if err != nil {
+            return err
+        }
+    }
+    err = os.RemoveAll(layers)
+    if err != nil {
+        return fmt.Errorf("Unable to remove %s with %w", layers, err)
+    }
+    return nil
+}
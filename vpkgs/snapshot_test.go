@@ -0,0 +1,197 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package vpkgs
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "io/ioutil"
+    "os"
+    str "strings"
+    "testing"
+)
+
+// Create a void-packages with a base-chroot and a stub xbps-src
+// The stub bootstraps a masterdir given with -m by creating its usr, and
+// appends each call to the calls file.
+func testSnapshotVpkgs(t *testing.T) (cfg.Cfgs, string) {
+    vpkgPath := t.TempDir()
+    calls := vpkgPath + "/calls"
+    script := "#!/bin/sh\necho \"$@\" >> '" + calls + "'\n" +
+        "if [ \"$1\" = -m ]; then mkdir -p \"$2/usr\" && touch \"$2/usr/bootstrapped\"; fi\n"
+    err := ioutil.WriteFile(vpkgPath + "/xbps-src", []byte(script), 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.MkdirAll(vpkgPath + "/srcpkgs/base-chroot", 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    writeBaseChroot(t, vpkgPath, "version=0.66\n")
+    return cfg.Cfgs{VpkgPath: vpkgPath, HostArch: "x86_64", Masterdir: "masterdir",
+        Snapshot: "reflink"}, calls
+}
+
+// Change the base-chroot template
+func writeBaseChroot(t *testing.T, vpkgPath string, template string) {
+    err := ioutil.WriteFile(vpkgPath + "/srcpkgs/base-chroot/template", []byte(template), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+}
+
+// How many times the stub xbps-src has bootstrapped
+func bootstraps(t *testing.T, calls string) int {
+    data, err := ioutil.ReadFile(calls)
+    if os.IsNotExist(err) {
+        return 0
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+    return str.Count(string(data[:]), "binary-bootstrap")
+}
+
+func TestRefreshPristine(t *testing.T) {
+    cfg, calls := testSnapshotVpkgs(t)
+    pristine := PristinePath(cfg)
+
+    err := RefreshPristine(ioutil.Discard, cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n := bootstraps(t, calls); n != 1 {
+        t.Fatalf("bootstrapped %d times, want 1", n)
+    }
+    hash, err := TemplateHash("base-chroot", cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    saved, err := ioutil.ReadFile(pristine + ".hash")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if str.TrimSpace(string(saved[:])) != hash {
+        t.Errorf("saved hash is %q, want %q", saved, hash)
+    }
+
+    // Nothing has changed, so it is kept
+    err = RefreshPristine(ioutil.Discard, cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n := bootstraps(t, calls); n != 1 {
+        t.Errorf("bootstrapped %d times with the same base-chroot, want 1", n)
+    }
+
+    // A changed base-chroot bootstraps it again from scratch
+    err = ioutil.WriteFile(pristine + "/stale", nil, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    writeBaseChroot(t, cfg.VpkgPath, "version=0.67\n")
+    err = RefreshPristine(ioutil.Discard, cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n := bootstraps(t, calls); n != 2 {
+        t.Errorf("bootstrapped %d times after base-chroot changed, want 2", n)
+    }
+    _, err = os.Stat(pristine + "/stale")
+    if !os.IsNotExist(err) {
+        t.Errorf("old pristine masterdir was not removed")
+    }
+    newHash, err := TemplateHash("base-chroot", cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    saved, err = ioutil.ReadFile(pristine + ".hash")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if str.TrimSpace(string(saved[:])) != newHash {
+        t.Errorf("saved hash is %q, want %q", saved, newHash)
+    }
+
+    // A missing pristine masterdir is bootstrapped even if the hash matches
+    err = os.RemoveAll(pristine)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = RefreshPristine(ioutil.Discard, cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n := bootstraps(t, calls); n != 3 {
+        t.Errorf("bootstrapped %d times after it was removed, want 3", n)
+    }
+}
+
+func TestRefreshPristineNone(t *testing.T) {
+    cfg, calls := testSnapshotVpkgs(t)
+    cfg.Snapshot = "none"
+    err := RefreshPristine(ioutil.Discard, cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n := bootstraps(t, calls); n != 0 {
+        t.Errorf("bootstrapped %d times without snapshots", n)
+    }
+    _, err = os.Stat(PristinePath(cfg))
+    if !os.IsNotExist(err) {
+        t.Errorf("pristine masterdir was created without snapshots")
+    }
+}
+
+func TestReflinkMasterdir(t *testing.T) {
+    cfg, calls := testSnapshotVpkgs(t)
+    err := RefreshPristine(ioutil.Discard, cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    cfg.Masterdir = "masterdir-foo"
+    err = CreateMasterdir("none", cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n := bootstraps(t, calls); n != 1 {
+        t.Errorf("bootstrapped %d times, want only the pristine masterdir", n)
+    }
+    _, err = os.Stat(MasterdirPath(cfg) + "/usr/bootstrapped")
+    if err != nil {
+        t.Fatalf("pristine masterdir was not cloned: %v", err)
+    }
+
+    // Changes to the clone don't reach the pristine masterdir
+    err = ioutil.WriteFile(MasterdirPath(cfg) + "/usr/built", nil, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = os.Stat(PristinePath(cfg) + "/usr/built")
+    if !os.IsNotExist(err) {
+        t.Errorf("pristine masterdir was changed through its clone")
+    }
+
+    err = RemoveMasterdir(cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if MasterdirExists(cfg) {
+        t.Errorf("clone was not removed")
+    }
+    _, err = os.Stat(PristinePath(cfg) + "/usr/bootstrapped")
+    if err != nil {
+        t.Errorf("pristine masterdir was removed with its clone: %v", err)
+    }
+}
+
+func TestCloneWithoutPristine(t *testing.T) {
+    cfg, _ := testSnapshotVpkgs(t)
+    cfg.Masterdir = "masterdir-foo"
+    err := CreateMasterdir("none", cfg)
+    if err == nil {
+        t.Errorf("cloned a pristine masterdir that does not exist")
+    }
+}
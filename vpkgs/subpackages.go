@@ -75,3 +75,14 @@ func ResolveSubpackages(pkgNames []string, arch string, cfg cfg.Cfgs) ([]string,
     }
     return basePkgs, nil
 }
+
+// Check if a dependency is a -32bit package for x86_64 multilib
+// These are not in srcpkgs/, but are generated from i686 builds.
+func IsMultilib(pkgName string, arch string, cfg cfg.Cfgs) bool {
+    if arch != "x86_64" || !str.HasSuffix(pkgName, "-32bit") {
+        return false
+    }
+    // Some real packages may end in -32bit
+    _, err := os.Stat(cfg.VpkgPath + "/srcpkgs/" + pkgName)
+    return os.IsNotExist(err)
+}
@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package vpkgs
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "os"
+    "testing"
+)
+
+func TestIsMultilib(t *testing.T) {
+    vpkgPath := t.TempDir()
+    // A real package whose name happens to end in -32bit
+    err := os.MkdirAll(vpkgPath + "/srcpkgs/wine-32bit", 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cfg := cfg.Cfgs{VpkgPath: vpkgPath}
+
+    tests := []struct {
+        pkgName string
+        arch string
+        multilib bool
+    }{
+        {"glibc-32bit", "x86_64", true},
+        {"libgcc-32bit", "x86_64", true},
+        {"glibc", "x86_64", false},
+        {"wine-32bit", "x86_64", false},
+        {"glibc-32bit", "i686", false},
+        {"glibc-32bit", "x86_64-musl", false},
+        {"glibc-32bit", "aarch64", false},
+        {"glibc-32bits", "x86_64", false},
+    }
+    for _, test := range tests {
+        t.Run(test.pkgName + "@" + test.arch, func(t *testing.T) {
+            got := IsMultilib(test.pkgName, test.arch, cfg)
+            if got != test.multilib {
+                t.Errorf("multilib is %t, want %t", got, test.multilib)
+            }
+        })
+    }
+}
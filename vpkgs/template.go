@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package vpkgs
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "fmt"
+    "io/ioutil"
+    "path"
+    "regexp"
+    str "strings"
+)
+
+// A top-level variable assignment in a template
+var templateVarRe = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)=(.*)$`)
+
+// Read the top-level variables of a template that dbulk-dump does not give us
+// This is not a shell, so only simple (possibly quoted, possibly multi-line)
+// assignments are understood, which is all templates use for these.
+func templateVars(pkgName string, cfg cfg.Cfgs) (map[string]string, error) {
+    vars := make(map[string]string)
+
+    fname := cfg.VpkgPath + "/srcpkgs/" + pkgName + "/template"
+    data, err := ioutil.ReadFile(fname)
+    if err != nil {
+        return vars, fmt.Errorf("Error %w reading %s", err, fname)
+    }
+    lines := str.Split(string(data[:]), "\n")
+
+    for i := 0; i < len(lines); i++ {
+        match := templateVarRe.FindStringSubmatch(lines[i])
+        if match == nil {
+            continue
+        }
+        name := match[1]
+        value := match[2]
+
+        // Quoted values may go over several lines
+        if str.HasPrefix(value, "\"") || str.HasPrefix(value, "'") {
+            quote := value[:1]
+            value = value[1:]
+            for !str.Contains(value, quote) && i + 1 < len(lines) {
+                i++
+                value += " " + lines[i]
+            }
+            if end := str.Index(value, quote); end >= 0 {
+                value = value[:end]
+            }
+        } else if end := str.IndexAny(value, " \t#"); end >= 0 {
+            value = value[:end]
+        }
+
+        vars[name] = str.Join(str.Fields(value), " ")
+    }
+
+    return vars, nil
+}
+
+// Check if a package is noarch (the same binpkg for every arch)
+func IsNoarch(pkgName string, cfg cfg.Cfgs) (bool, error) {
+    vars, err := templateVars(pkgName, cfg)
+    if err != nil {
+        return false, err
+    }
+    return vars["archs"] == "noarch", nil
+}
+
+// Check if an archs= list allows an arch
+// This follows xbps-src: the first pattern matching the arch decides, and a
+// ~ in front of a pattern negates it.
+func archAllowed(archs string, arch string) bool {
+    if archs == "" || archs == "noarch" {
+        return true
+    }
+
+    match := false
+    negated := false
+    for _, pattern := range str.Fields(archs) {
+        negated = str.HasPrefix(pattern, "~")
+        matched, _ := path.Match(str.TrimPrefix(pattern, "~"), arch)
+        if matched {
+            match = true
+            break
+        }
+    }
+
+    return match != negated
+}
+
+// Check if a package can be built for an arch
+// If not, the reason why is also given.
+func Buildable(pkg Pkg, arch string, cfg cfg.Cfgs) (bool, string) {
+    if pkg.Broken != "" {
+        return false, "broken: " + pkg.Broken
+    }
+    if pkg.Restricted && !cfg.AllowRestricted {
+        return false, "restricted"
+    }
+    if !archAllowed(pkg.Archs, arch) {
+        return false, "archs: " + pkg.Archs
+    }
+    if pkg.Nocross != "" && arch != cfg.HostArch {
+        return false, "nocross: " + pkg.Nocross
+    }
+    return true, ""
+}
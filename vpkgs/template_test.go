@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package vpkgs
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "testing"
+)
+
+func TestArchAllowed(t *testing.T) {
+    tests := []struct {
+        archs string
+        arch string
+        allowed bool
+    }{
+        {"", "x86_64", true},
+        {"noarch", "aarch64", true},
+        {"x86_64", "x86_64", true},
+        {"x86_64", "aarch64", false},
+        {"x86_64* i686", "x86_64-musl", true},
+        {"x86_64* i686", "i686", true},
+        {"x86_64* i686", "armv7l", false},
+        {"aarch64 armv*", "armv6l-musl", true},
+        {"~i686", "i686", false},
+        {"~i686", "x86_64", true},
+        {"~*-musl", "x86_64-musl", false},
+        {"~*-musl", "x86_64", true},
+        // The first pattern matching decides
+        {"~armv6l* armv*", "armv6l", false},
+        {"~armv6l* armv*", "armv7l", true},
+        {"x86_64 ~x86_64", "x86_64", true},
+        // Without a match, a negated last pattern allows it, like xbps-src
+        {"~i686 ~armv*", "aarch64", true},
+        {"x86_64 ~armv*", "aarch64", true},
+        {"~armv* x86_64", "aarch64", false},
+    }
+    for _, test := range tests {
+        t.Run(test.archs + "/" + test.arch, func(t *testing.T) {
+            got := archAllowed(test.archs, test.arch)
+            if got != test.allowed {
+                t.Errorf("allowed is %t, want %t", got, test.allowed)
+            }
+        })
+    }
+}
+
+func TestBuildable(t *testing.T) {
+    tests := []struct {
+        name string
+        pkg Pkg
+        arch string
+        allowRestricted bool
+        buildable bool
+        reason string
+    }{
+        {"plain", Pkg{}, "x86_64", false, true, ""},
+        {"broken", Pkg{Broken: "fails to compile"}, "x86_64", false, false, "broken: fails to compile"},
+        {"restricted", Pkg{Restricted: true}, "x86_64", false, false, "restricted"},
+        {"restricted allowed", Pkg{Restricted: true}, "x86_64", true, true, ""},
+        {"wrong arch", Pkg{Archs: "x86_64*"}, "aarch64", false, false, "archs: x86_64*"},
+        {"negated arch", Pkg{Archs: "~aarch64*"}, "aarch64", false, false, "archs: ~aarch64*"},
+        {"noarch native", Pkg{Archs: "noarch", Noarch: true}, "x86_64", false, true, ""},
+        {"noarch cross", Pkg{Archs: "noarch", Noarch: true}, "aarch64", false, true, ""},
+        {"nocross native", Pkg{Nocross: "yes"}, "x86_64", false, true, ""},
+        {"nocross cross", Pkg{Nocross: "yes"}, "aarch64", false, false, "nocross: yes"},
+        {"nocross noarch cross", Pkg{Archs: "noarch", Noarch: true, Nocross: "uses the host"}, "aarch64",
+            false, false, "nocross: uses the host"},
+        // Broken is reported over anything else
+        {"broken and nocross", Pkg{Broken: "yes", Nocross: "yes"}, "aarch64", false, false, "broken: yes"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            cfg := cfg.Cfgs{HostArch: "x86_64", AllowRestricted: test.allowRestricted}
+            buildable, reason := Buildable(test.pkg, test.arch, cfg)
+            if buildable != test.buildable || reason != test.reason {
+                t.Errorf("got %t, %q, want %t, %q", buildable, reason, test.buildable, test.reason)
+            }
+        })
+    }
+}
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2021 fosslinux <fosslinux@aussies.space>
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package vpkgs
+
+import (
+    "github.com/fosslinux/vxb/cfg"
+    "fmt"
+    "os"
+    "path/filepath"
+)
+
+// List every base package with a template in srcpkgs/
+func AllPkgs(cfg cfg.Cfgs) ([]string, error) {
+    var pkgNames []string
+    seen := make(map[string]bool)
+
+    srcpkgs := cfg.VpkgPath + "/srcpkgs"
+    dir, err := os.Open(srcpkgs)
+    if err != nil {
+        return []string{}, fmt.Errorf("Error %w opening %s", err, srcpkgs)
+    }
+    within, err := dir.Readdir(0)
+    dir.Close()
+    if err != nil {
+        return []string{}, fmt.Errorf("Error %w listing packages within %s", err, srcpkgs)
+    }
+
+    for _, f := range within {
+        pkgName := f.Name()
+        // Subpackages are symlinks to their base package
+        if f.Mode() & os.ModeSymlink != 0 {
+            target, err := os.Readlink(srcpkgs + "/" + pkgName)
+            if err != nil {
+                return []string{}, fmt.Errorf("Error %w resolving %s", err, srcpkgs + "/" + pkgName)
+            }
+            pkgName = filepath.Base(target)
+        }
+        if seen[pkgName] {
+            continue
+        }
+
+        // Only directories with a template are packages
+        _, err = os.Stat(srcpkgs + "/" + pkgName + "/template")
+        if err != nil {
+            continue
+        }
+
+        seen[pkgName] = true
+        pkgNames = append(pkgNames, pkgName)
+    }
+
+    return pkgNames, nil
+}
+
+// List every base package that is not ready for an arch
+func Universe(arch string, cfg cfg.Cfgs) ([]string, error) {
+    pkgNames, err := AllPkgs(cfg)
+    if err != nil {
+        return []string{}, err
+    }
+
+    // Run checkvers only once for everything
+    vers, err := checkvers(arch, cfg)
+    if err != nil {
+        return []string{}, err
+    }
+
+    var notReady []string
+    for _, pkgName := range pkgNames {
+        if !vers.ready(pkgName) {
+            notReady = append(notReady, pkgName)
+        }
+    }
+
+    return notReady, nil
+}
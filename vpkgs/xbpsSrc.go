@@ -6,32 +6,21 @@ package vpkgs
 
 import (
     "github.com/fosslinux/vxb/cfg"
+    "github.com/fosslinux/vxb/util"
     "fmt"
     "errors"
     "os"
+    "io"
     "os/exec"
     str "strings"
-    "bufio"
-    "sync"
+    "time"
 )
 
-// Run an xbps-src command
-func XbpsSrc(sArgs string, arch string, mountType string, rtOut bool, cfg cfg.Cfgs) ([]byte, error) {
-    var err error
-    errRet := make([]byte, 1)
-    errRet[0] = 0
-
-    curDir, err := os.Getwd()
-    if err != nil {
-        return errRet, errors.New("Error getting current working directory")
-    }
-
-    err = os.Chdir(cfg.VpkgPath)
-    if err != nil {
-        return errRet, fmt.Errorf("Unable to change directory into %s", cfg.VpkgPath)
-    }
-
+// Arguments to give xbps-src for a command
+// masterdir is the path of the masterdir to use, or empty for the default.
+func XbpsSrcArgs(sArgs string, arch string, masterdir string, cfg cfg.Cfgs) []string {
     aArgs := str.Fields(sArgs)
+    bootstrap := aArgs[0] == "binary-bootstrap"
 
     // Add -r xyz if we need to
     repo, repoExists := cfg.SubRepos[arch]
@@ -39,85 +28,89 @@ func XbpsSrc(sArgs string, arch string, mountType string, rtOut bool, cfg cfg.Cf
         aArgs = append(aArgs, "-r", repo)
     }
 
+    // Use a different masterdir if we were told to
+    if masterdir != "" {
+        aArgs = append([]string{"-m", masterdir}, aArgs...)
+    }
+
+    // We should not use -a natively
+    if cfg.HostArch != arch && !bootstrap {
+        aArgs = append([]string{"-a", arch}, aArgs...)
+    }
+
+    return aArgs
+}
+
+// Create the command for running xbps-src
+func xbpsSrcCmd(sArgs string, arch string, cfg cfg.Cfgs) (*exec.Cmd, error) {
+    bootstrap := str.HasPrefix(sArgs, "binary-bootstrap")
+
     // Create the masterdir
     // If we are binary-bootstrapping we don't care though
-    if aArgs[0] != "binary-bootstrap" {
+    if !bootstrap {
         // Check a masterdir exists
-        _, err := os.Stat(cfg.VpkgPath + "/masterdir/usr")
+        _, err := os.Stat(MasterdirPath(cfg) + "/usr")
         if os.IsNotExist(err) {
-            return errRet, errors.New("masterdir not bootstrapped")
+            return nil, errors.New("masterdir not bootstrapped")
         }
     }
 
-    // Run the actual command
-    var cmd *exec.Cmd
-    if cfg.HostArch == arch || aArgs[0] == "binary-bootstrap" {
-        // We should not use -a
-        cmd = exec.Command("./xbps-src", aArgs...)
-    } else {
-        cmd = exec.Command("./xbps-src", append([]string{"-a", arch}, aArgs...)...)
+    masterdir := ""
+    if cfg.Masterdir != "masterdir" {
+        masterdir = MasterdirPath(cfg)
     }
 
-    var out []byte
-    if rtOut {
-        // Get the stdout pipe and stream (bufio) it to our stdout
-        stdout, err := cmd.StdoutPipe()
-        if err != nil {
-            goto errHandler
-        }
-        stderr, err := cmd.StderrPipe()
-        if err != nil {
-            goto errHandler
-        }
-
-        cmd.Start()
-
-        // Greate goroutines for stdout and stderr so they can be outputted together
-        var wg sync.WaitGroup
-
-        wg.Add(2)
-        go func(wg *sync.WaitGroup) {
-            defer wg.Done()
+    // Run the actual command
+    // This is run from within VpkgPath without changing our own directory
+    // so that many may be run at once
+    cmd := exec.Command("./xbps-src", XbpsSrcArgs(sArgs, arch, masterdir, cfg)...)
+    cmd.Dir = cfg.VpkgPath
 
-            scanner := bufio.NewScanner(stdout)
-            for scanner.Scan() {
-                l := scanner.Text()
-                fmt.Println(l)
-            }
-        }(&wg)
+    return cmd, nil
+}
 
-        go func(wg *sync.WaitGroup) {
-            defer wg.Done()
+// Run an xbps-src command, streaming its output as it runs
+// stdout and stderr may be the same writer. If it takes longer than timeout
+// (0 means forever), it and everything it started is killed, and the error
+// wraps util.ErrTimeout.
+func XbpsSrcStream(sArgs string, arch string, timeout time.Duration, stdout io.Writer, stderr io.Writer, cfg cfg.Cfgs) error {
+    cmd, err := xbpsSrcCmd(sArgs, arch, cfg)
+    if err != nil {
+        return err
+    }
+    cmd.Stdout = stdout
+    cmd.Stderr = stderr
 
-            scanner := bufio.NewScanner(stderr)
-            for scanner.Scan() {
-                l := scanner.Text()
-                fmt.Fprintln(os.Stderr, l)
-            }
-        }(&wg)
+    err = util.RunTimeout(cmd, timeout)
+    if err != nil {
+        RemoveMasterdir(cfg)
+        return fmt.Errorf("Error %w while executing %s", err, cmd.Args)
+    }
+    return nil
+}
 
-        wg.Wait()
-        err = cmd.Wait()
-        if err != nil {
-            goto errHandler
-        }
+// Run an xbps-src command
+// If rtOut is set, the output is streamed to our own stdout and stderr
+// instead of being returned.
+func XbpsSrc(sArgs string, arch string, mountType string, rtOut bool, cfg cfg.Cfgs) ([]byte, error) {
+    errRet := make([]byte, 1)
+    errRet[0] = 0
 
+    if rtOut {
         // We have nothing to return (errRet is just empty)
-        out = errRet
-    } else {
-        out, err = cmd.CombinedOutput()
-        if err != nil {
-            goto errHandler
-        }
+        return errRet, XbpsSrcStream(sArgs, arch, 0, os.Stdout, os.Stderr, cfg)
     }
 
-    // Cleanup
-    os.Chdir(curDir)
+    cmd, err := xbpsSrcCmd(sArgs, arch, cfg)
+    if err != nil {
+        return errRet, err
+    }
+    out, err := cmd.CombinedOutput()
+    if err != nil {
+        RemoveMasterdir(cfg)
+        fmt.Printf("%s\n", string(out[:]))
+        return out, fmt.Errorf("Error %w while executing %s", err, cmd.Args)
+    }
 
     return out, nil
-
-errHandler:
-    RemoveMasterdir(cfg)
-    fmt.Printf("%s\n", string(out[:]))
-    return out, fmt.Errorf("Error %w while executing %s", err, cmd.Args)
 }